@@ -0,0 +1,207 @@
+package mfsng
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// WriteTarGz writes every file and directory in the FS to w as a gzip compressed tar archive,
+// using the supplied gzip compression level. Both the archive and its compression are streamed so
+// memory use does not depend on the size of the tree. The gzip stream is closed whether or not
+// an error occurs, so w always receives a complete gzip stream.
+func (fsys *FS) WriteTarGz(ctx context.Context, w io.Writer, level int) error {
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return fmt.Errorf("new gzip writer: %w", err)
+	}
+
+	if err := fsys.writeTar(ctx, "writetargz", ".", zw); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("close gzip writer: %w", err)
+	}
+	return nil
+}
+
+// WriteTar writes every file, directory and symbolic link beneath the directory root to w as a tar
+// archive. Entry names are relative to root, which may be "." to archive the whole FS, and each
+// entry's mode and modification time are taken from its UnixFS metadata. File content is streamed
+// so memory use does not depend on the size of the tree.
+func (fsys *FS) WriteTar(ctx context.Context, w io.Writer, root string) error {
+	if err := ValidatePath(root); err != nil {
+		return &fs.PathError{
+			Op:   "writetar",
+			Path: root,
+			Err:  err,
+		}
+	}
+	return fsys.writeTar(ctx, "writetar", root, w)
+}
+
+// writeTar writes every entry beneath the directory root to w as a tar archive, reporting errors
+// using the supplied op.
+func (fsys *FS) writeTar(ctx context.Context, op string, root string, w io.Writer) error {
+	d, err := fsys.openDir(ctx, op, root)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := fsys.walkTree(ctx, "", d, func(p string, _ *ipld.Link, e entry) error {
+		return writeTarEntry(p, e, tw)
+	}, nil); err != nil {
+		return newPathError(op, root, d.Cid(), err)
+	}
+	if err := tw.Close(); err != nil {
+		return newPathError(op, root, d.Cid(), fmt.Errorf("close tar writer: %w", err))
+	}
+	return nil
+}
+
+// writeTarEntry writes the header for the entry at p to tw, followed by the content of a file.
+func writeTarEntry(p string, e entry, tw *tar.Writer) error {
+	info := e.fileInfo()
+	switch e := e.(type) {
+	case *Dir:
+		hdr := &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     p + "/",
+			Mode:     int64(permOrDefault(info.filemode, 0o755)),
+			ModTime:  info.modtime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("%s: write header: %w", p, err)
+		}
+	case *File:
+		if err := writeTarFile(p, e, tw); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	case *Symlink:
+		hdr := &tar.Header{
+			Typeflag: tar.TypeSymlink,
+			Name:     p,
+			Linkname: e.Target(),
+			Mode:     int64(permOrDefault(info.filemode, 0o777)),
+			ModTime:  info.modtime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("%s: write header: %w", p, err)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported entry type: %w", p, fs.ErrInvalid)
+	}
+	return nil
+}
+
+func writeTarFile(p string, f *File, tw *tar.Writer) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     p,
+		Size:     f.info.size,
+		Mode:     int64(permOrDefault(f.info.filemode, 0o644)),
+		ModTime:  f.info.modtime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if _, err := f.WriteTo(tw); err != nil {
+		return fmt.Errorf("write content: %w", err)
+	}
+	return nil
+}
+
+// WriteZip writes every file, directory and symbolic link beneath the directory root to w as a zip
+// archive. Entry names are relative to root, which may be "." to archive the whole FS, directories
+// are written as entries with a trailing slash, and symbolic links are stored with their target as
+// content. Files are compressed with deflate and their content is streamed through the archive so
+// memory use does not depend on the size of the tree.
+func (fsys *FS) WriteZip(ctx context.Context, w io.Writer, root string) error {
+	const op = "writezip"
+	if err := ValidatePath(root); err != nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: root,
+			Err:  err,
+		}
+	}
+
+	d, err := fsys.openDir(ctx, op, root)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	if err := fsys.walkTree(ctx, "", d, func(p string, _ *ipld.Link, e entry) error {
+		return writeZipEntry(p, e, zw)
+	}, nil); err != nil {
+		return newPathError(op, root, d.Cid(), err)
+	}
+	if err := zw.Close(); err != nil {
+		return newPathError(op, root, d.Cid(), fmt.Errorf("close zip writer: %w", err))
+	}
+	return nil
+}
+
+// writeZipEntry writes the entry at p to zw, including the content of a file.
+func writeZipEntry(p string, e entry, zw *zip.Writer) error {
+	info := e.fileInfo()
+	switch e := e.(type) {
+	case *Dir:
+		hdr := &zip.FileHeader{
+			Name:     p + "/",
+			Method:   zip.Store,
+			Modified: info.modtime,
+		}
+		hdr.SetMode(fs.ModeDir | permOrDefault(info.filemode, 0o755))
+		if _, err := zw.CreateHeader(hdr); err != nil {
+			return fmt.Errorf("%s: write header: %w", p, err)
+		}
+	case *File:
+		if err := writeZipFile(p, e, zw); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	case *Symlink:
+		hdr := &zip.FileHeader{
+			Name:     p,
+			Method:   zip.Store,
+			Modified: info.modtime,
+		}
+		hdr.SetMode(fs.ModeSymlink | permOrDefault(info.filemode, 0o777))
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("%s: write header: %w", p, err)
+		}
+		if _, err := io.WriteString(fw, e.Target()); err != nil {
+			return fmt.Errorf("%s: write target: %w", p, err)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported entry type: %w", p, fs.ErrInvalid)
+	}
+	return nil
+}
+
+func writeZipFile(p string, f *File, zw *zip.Writer) error {
+	hdr := &zip.FileHeader{
+		Name:     p,
+		Method:   zip.Deflate,
+		Modified: f.info.modtime,
+	}
+	hdr.SetMode(permOrDefault(f.info.filemode, 0o644))
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if _, err := f.WriteTo(fw); err != nil {
+		return fmt.Errorf("write content: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package mfsng
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// A CarOption configures how ReadFSFromCar reads a CAR.
+type CarOption func(*carConfig)
+
+type carConfig struct {
+	root cid.Cid
+}
+
+// WithCarRoot selects the block with the supplied CID as the root of the FS instead of the first
+// root listed in the CAR's header. The block must be present in the CAR but need not be listed as
+// one of its roots.
+func WithCarRoot(c cid.Cid) CarOption {
+	return func(cfg *carConfig) {
+		cfg.root = c
+	}
+}
+
+// maxCarSectionSize is the largest header or block ReadFSFromCar accepts, guarding against
+// corrupt lengths causing enormous allocations.
+const maxCarSectionSize = 32 << 20
+
+// carV2HeaderSize is the size of the CARv2 pragma and the fixed length header that follows it.
+const carV2HeaderSize = 11 + 40
+
+// carV2Pragma is the fixed prefix of a CARv2 file: a CARv1 style header announcing version 2.
+var carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// ReadFSFromCar returns a read-only filesystem over the blocks of a CARv1 or CARv2 stream. The
+// root of the FS is the first root listed in the CAR's header unless another is selected using
+// WithCarRoot, and it must be a UnixFS directory. Every block in the CAR is read into memory, and
+// blocks are not verified against their CIDs unless the FS is derived using WithVerifyBlocks.
+func ReadFSFromCar(r io.Reader, opts ...CarOption) (*FS, error) {
+	var cfg carConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	br := bufio.NewReader(r)
+	pragma, err := br.Peek(len(carV2Pragma))
+	if err == nil && bytes.Equal(pragma, carV2Pragma) {
+		payload, err := carV2Payload(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(payload)
+	}
+
+	roots, err := readCarHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	getter := make(blockGetter)
+	for {
+		b, err := readCarBlock(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		getter[b.Cid()] = b
+	}
+
+	root := cfg.root
+	if !root.Defined() {
+		if len(roots) == 0 {
+			return nil, fmt.Errorf("car header lists no roots: %w", fs.ErrInvalid)
+		}
+		root = roots[0]
+	}
+
+	node, err := getter.Get(context.Background(), root)
+	if err != nil {
+		return nil, fmt.Errorf("get root node: %w", err)
+	}
+	fsys, err := ReadFS(node, getter)
+	if err != nil {
+		return nil, fmt.Errorf("root %s: %w", root, err)
+	}
+	return fsys, nil
+}
+
+// carV2Payload reads the CARv2 header from r and returns a reader over the CARv1 payload it wraps.
+func carV2Payload(r *bufio.Reader) (io.Reader, error) {
+	// The pragma is followed by 16 bytes of characteristics and the little endian offset and size
+	// of the payload and the offset of the index
+	var hdr [carV2HeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("read carv2 header: %w", err)
+	}
+	dataOffset := binary.LittleEndian.Uint64(hdr[len(carV2Pragma)+16:])
+	dataSize := binary.LittleEndian.Uint64(hdr[len(carV2Pragma)+24:])
+	if dataOffset < uint64(len(hdr)) {
+		return nil, fmt.Errorf("carv2 data offset %d is within the header: %w", dataOffset, fs.ErrInvalid)
+	}
+
+	if _, err := io.CopyN(io.Discard, r, int64(dataOffset)-int64(len(hdr))); err != nil {
+		return nil, fmt.Errorf("skip to carv2 data: %w", err)
+	}
+	return io.LimitReader(r, int64(dataSize)), nil
+}
+
+// readCarHeader reads a CARv1 header from r and returns the roots it lists.
+func readCarHeader(r *bufio.Reader) ([]cid.Cid, error) {
+	data, err := readCarSection(r)
+	if err != nil {
+		return nil, fmt.Errorf("read car header: %w", err)
+	}
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("decode car header: %w", err)
+	}
+	hdr := nb.Build()
+
+	vn, err := hdr.LookupByString("version")
+	if err != nil {
+		return nil, fmt.Errorf("car header version: %w", err)
+	}
+	if version, err := vn.AsInt(); err != nil || version != 1 {
+		return nil, fmt.Errorf("unsupported car version: %w", fs.ErrInvalid)
+	}
+
+	rn, err := hdr.LookupByString("roots")
+	if err != nil {
+		return nil, fmt.Errorf("car header roots: %w", err)
+	}
+	var roots []cid.Cid
+	it := rn.ListIterator()
+	for it != nil && !it.Done() {
+		_, ln, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("car header roots: %w", err)
+		}
+		l, err := ln.AsLink()
+		if err != nil {
+			return nil, fmt.Errorf("car header root: %w", err)
+		}
+		cl, ok := l.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("car header root is not a cid: %w", fs.ErrInvalid)
+		}
+		roots = append(roots, cl.Cid)
+	}
+	return roots, nil
+}
+
+// readCarBlock reads the next block from the CARv1 payload in r, returning io.EOF when there are
+// no more blocks.
+func readCarBlock(r *bufio.Reader) (blocks.Block, error) {
+	data, err := readCarSection(r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read car block: %w", err)
+	}
+
+	n, c, err := cid.CidFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("read car block cid: %w", err)
+	}
+	b, err := blocks.NewBlockWithCid(data[n:], c)
+	if err != nil {
+		return nil, fmt.Errorf("new block %s: %w", c, err)
+	}
+	return b, nil
+}
+
+// readCarSection reads a varint length prefixed section from r. It returns io.EOF only if r is
+// exhausted before the section starts.
+func readCarSection(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read section length: %w", err)
+	}
+	if size == 0 || size > maxCarSectionSize {
+		return nil, fmt.Errorf("section length %d out of range: %w", size, fs.ErrInvalid)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("read section: %w", err)
+	}
+	return data, nil
+}
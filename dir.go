@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"sort"
 	"sync"
 
 	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
 	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 )
 
@@ -20,8 +23,12 @@ type Dir struct {
 	ctx    context.Context // an embedded context for cancellation and deadline propogation
 	info   FileInfo
 
-	namesOnce sync.Once
-	names     []string // names is written once by namesOnce and read-only thereafter
+	linksOnce sync.Once
+	links     []*ipld.Link // links is written once by linksOnce and read-only thereafter
+	linksErr  error        // linksErr is written once by linksOnce and read-only thereafter
+
+	sortedOnce sync.Once
+	sorted     []*ipld.Link // sorted is written once by sortedOnce and read-only thereafter
 
 	mu     sync.Mutex // guards access to all of following fields
 	offset int        // number of entries read by prior calls to ReadDir
@@ -36,21 +43,30 @@ func newDir(ctx context.Context, name string, node ipld.Node, getter ipld.NodeGe
 }
 
 func newDirFromUnixFS(ctx context.Context, name string, node ipld.Node, getter ipld.NodeGetter, udir uio.Directory) (*Dir, error) {
+	// The cumulative size of the directory's DAG, see FileInfo.Size
 	size, err := node.Size()
 	if err != nil {
 		return nil, err
 	}
 
+	info := FileInfo{
+		name:     name,
+		size:     int64(size),
+		filemode: fs.ModeDir | defaultDirPerm,
+		node:     node,
+	}
+	if pn, ok := node.(*merkledag.ProtoNode); ok {
+		if fsn, err := unixfs.FSNodeFromBytes(pn.Data()); err == nil {
+			info.filemode = fs.ModeDir | unixfsMode(fsn)
+			info.modtime = fsn.ModTime()
+		}
+	}
+
 	return &Dir{
 		udir:   udir,
 		getter: getter,
 		ctx:    ctx,
-		info: FileInfo{
-			name:     name,
-			size:     int64(size),
-			filemode: fs.ModeDir,
-			node:     node,
-		},
+		info:   info,
 	}, nil
 }
 
@@ -63,6 +79,10 @@ func (d *Dir) Name() string               { return d.info.name }
 func (d *Dir) IsDir() bool                { return true }
 func (d *Dir) Info() (fs.FileInfo, error) { return d.Stat() }
 func (d *Dir) Type() fs.FileMode          { return fs.ModeDir }
+func (d *Dir) fileInfo() *FileInfo        { return &d.info }
+
+// Cid returns the CID of the directory's root node.
+func (d *Dir) Cid() cid.Cid { return d.info.Cid() }
 
 func (d *Dir) Read([]byte) (int, error) {
 	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
@@ -74,6 +94,7 @@ func (d *Dir) Close() error {
 }
 
 // ReadDir reads the contents of the directory and returns a slice of up to n DirEntry values in directory order.
+// Each entry is a *DirEntry whose node is not loaded until its type or info is requested.
 // Subsequent calls on the same file will yield further DirEntry values.
 // If n > 0, ReadDir returns at most n DirEntry structures.
 // In this case, if ReadDir returns an empty slice, it will return
@@ -86,21 +107,7 @@ func (d *Dir) Close() error {
 // If it encounters an error before the end of the directory,
 // ReadDir returns the DirEntry list read until that point and a non-nil error.
 func (d *Dir) ReadDir(limit int) ([]fs.DirEntry, error) {
-	// Read the names once
-	var err error
-	d.namesOnce.Do(func() {
-		var names []string
-		listErr := d.udir.ForEachLink(d.ctx, func(l *ipld.Link) error {
-			names = append(names, l.Name)
-			return nil
-		})
-		if listErr != nil {
-			err = fmt.Errorf("list names: %w", listErr)
-			return
-		}
-		d.names = names
-		d.offset = 0
-	})
+	links, err := d.listLinks()
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +116,7 @@ func (d *Dir) ReadDir(limit int) ([]fs.DirEntry, error) {
 	offset := d.offset
 	d.mu.Unlock()
 
-	n := len(d.names) - offset
+	n := len(links) - offset
 	if n == 0 && limit > 0 {
 		return nil, io.EOF
 	}
@@ -119,22 +126,7 @@ func (d *Dir) ReadDir(limit int) ([]fs.DirEntry, error) {
 
 	entries := make([]fs.DirEntry, n)
 	for i := range entries {
-		name := d.names[offset+i]
-
-		entry, err := dirEntry(d.ctx, d.getter, d.udir, name)
-		if err != nil {
-			d.mu.Lock()
-			d.offset += i
-			d.mu.Unlock()
-
-			return entries, &fs.PathError{
-				Op:   "readdir",
-				Path: name,
-				Err:  err,
-			}
-		}
-
-		entries[i] = entry
+		entries[i] = newDirEntry(d.ctx, d.getter, links[offset+i])
 	}
 
 	d.mu.Lock()
@@ -142,3 +134,148 @@ func (d *Dir) ReadDir(limit int) ([]fs.DirEntry, error) {
 	d.mu.Unlock()
 	return entries, nil
 }
+
+// EntryAt returns the entry at index i of the directory's entries sorted by name, resolving only
+// that entry. The directory's links are listed once and retained for subsequent calls. For a HAMT
+// sharded directory this requires loading every internal node of the shard on the first call.
+func (d *Dir) EntryAt(i int) (fs.DirEntry, error) {
+	links, err := d.listLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	d.sortedOnce.Do(func() {
+		d.sorted = make([]*ipld.Link, len(links))
+		copy(d.sorted, links)
+		sort.Slice(d.sorted, func(i, j int) bool { return d.sorted[i].Name < d.sorted[j].Name })
+	})
+
+	if i < 0 || i >= len(d.sorted) {
+		return nil, &fs.PathError{Op: "entryat", Path: d.info.name, Err: fmt.Errorf("index %d out of range: %w", i, fs.ErrInvalid)}
+	}
+
+	l := d.sorted[i]
+	entry, err := linkEntry(d.ctx, d.getter, l)
+	if err != nil {
+		return nil, newPathError("entryat", l.Name, l.Cid, err)
+	}
+	return entry, nil
+}
+
+// RawData returns the serialized bytes of the directory's root node, which hash to the directory's
+// CID. For a HAMT sharded directory these are the bytes of the top level shard node only; the
+// internal nodes of the shard are separate blocks. The returned slice may be modified by the caller.
+func (d *Dir) RawData() ([]byte, error) {
+	node := d.info.node
+	pn, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return append([]byte(nil), node.RawData()...), nil
+	}
+
+	data, err := pn.EncodeProtobuf(false)
+	if err != nil {
+		return nil, newPathError("rawdata", d.info.name, node.Cid(), fmt.Errorf("encode protobuf: %w", err))
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// listLinks returns the links of the directory in directory order, listing them on the first call.
+func (d *Dir) listLinks() ([]*ipld.Link, error) {
+	d.linksOnce.Do(func() {
+		var links []*ipld.Link
+		if err := forEachLink(d.ctx, d.udir, func(l *ipld.Link) bool {
+			links = append(links, l)
+			return true
+		}); err != nil {
+			d.linksErr = err
+			return
+		}
+		d.links = links
+	})
+	return d.links, d.linksErr
+}
+
+// A CidEntry is a file, directory or directory entry that records the CID of its root node. Every
+// fs.DirEntry returned by the FS, and every fs.File and fs.FileInfo, implements CidEntry, so callers
+// can obtain a CID with a type assertion instead of resolving the entry's path again.
+type CidEntry interface {
+	Cid() cid.Cid
+}
+
+var (
+	_ CidEntry = (*DirEntry)(nil)
+	_ CidEntry = (*Dir)(nil)
+	_ CidEntry = (*File)(nil)
+	_ CidEntry = (*Symlink)(nil)
+	_ CidEntry = (*FileInfo)(nil)
+)
+
+// A DirEntry is an entry read from a directory. Its name and CID are recorded in the directory so
+// they are available without loading the entry's node. The node is loaded on the first call to
+// IsDir, Type or Info, except that an entry whose CID uses a codec other than dag-pb is known to
+// be a file and is only loaded by Info.
+type DirEntry struct {
+	link   *ipld.Link
+	getter ipld.NodeGetter
+	ctx    context.Context
+
+	once sync.Once
+	e    entry // e is written once by once and read-only thereafter
+	err  error // err is written once by once and read-only thereafter
+}
+
+var _ fs.DirEntry = (*DirEntry)(nil)
+
+func newDirEntry(ctx context.Context, getter ipld.NodeGetter, l *ipld.Link) *DirEntry {
+	return &DirEntry{
+		link:   l,
+		getter: getter,
+		ctx:    ctx,
+	}
+}
+
+// Name returns the name of the entry.
+func (de *DirEntry) Name() string { return de.link.Name }
+
+// Cid returns the CID of the entry's root node.
+func (de *DirEntry) Cid() cid.Cid { return de.link.Cid }
+
+// IsDir reports whether the entry is a directory, loading the entry's node if necessary.
+func (de *DirEntry) IsDir() bool { return de.Type().IsDir() }
+
+// Type returns the type bits of the entry, loading the entry's node if necessary. If the node
+// cannot be loaded the type is reported as fs.ModeIrregular and Info returns the error.
+func (de *DirEntry) Type() fs.FileMode {
+	if de.link.Cid.Type() != cid.DagProtobuf {
+		return 0
+	}
+	e, err := de.load()
+	if err != nil {
+		return fs.ModeIrregular
+	}
+	return e.fileInfo().Mode().Type()
+}
+
+// Info returns a FileInfo describing the entry, loading the entry's node if necessary.
+func (de *DirEntry) Info() (fs.FileInfo, error) {
+	e, err := de.load()
+	if err != nil {
+		return nil, err
+	}
+	return e.fileInfo(), nil
+}
+
+// load resolves the entry's node on the first call.
+func (de *DirEntry) load() (entry, error) {
+	de.once.Do(func() {
+		e, err := linkEntry(de.ctx, de.getter, de.link)
+		if err != nil {
+			de.err = newPathError("stat", de.link.Name, de.link.Cid, err)
+			return
+		}
+		// Only the entry's metadata is retained
+		e.Close()
+		de.e = e
+	})
+	return de.e, de.err
+}
@@ -0,0 +1,76 @@
+package mfsng
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// ErrBlockVerification is the error reported when the data of a block does not hash to its CID.
+var ErrBlockVerification = errors.New("block data does not match cid")
+
+// ErrInvalidRange is the error reported when a requested byte range does not lie within a file.
+var ErrInvalidRange = errors.New("invalid range")
+
+// A NodeError records an error and the operation and path that caused it, along with the CID of the
+// node involved. When the error was caused by a missing block the CID is that of the missing block,
+// otherwise it is the CID of the node being resolved when the error occurred.
+type NodeError struct {
+	*fs.PathError
+	Cid cid.Cid
+}
+
+func (e *NodeError) Error() string { return e.PathError.Error() + " (cid " + e.Cid.String() + ")" }
+
+func (e *NodeError) Unwrap() error { return e.PathError }
+
+// newPathError returns a *NodeError if a CID can be associated with the error, otherwise it returns
+// an *fs.PathError.
+func newPathError(op, path string, c cid.Cid, err error) error {
+	c = missingCid(err, c)
+	pe := &fs.PathError{
+		Op:   op,
+		Path: path,
+		Err:  err,
+	}
+	if !c.Defined() {
+		return pe
+	}
+	return &NodeError{PathError: pe, Cid: c}
+}
+
+// errNotDir returns an error wrapping fs.ErrInvalid and syscall.ENOTDIR that reports the node is not
+// a UnixFS directory, naming the node's codec when it is not a dag-pb node.
+func errNotDir(node ipld.Node) error {
+	if node.Cid().Type() != cid.DagProtobuf {
+		return &notDirError{msg: fmt.Sprintf("node with codec %#x is not a UnixFS directory", node.Cid().Type())}
+	}
+	return &notDirError{msg: "not a UnixFS directory"}
+}
+
+// notDirError is the error returned by errNotDir. It is a distinct type so that a path that cannot
+// exist because it passes through a file can be told apart from other invalid arguments.
+type notDirError struct {
+	msg string
+}
+
+func (e *notDirError) Error() string { return e.msg + ": " + fs.ErrInvalid.Error() }
+
+func (e *notDirError) Unwrap() error { return fs.ErrInvalid }
+
+// Is reports whether target is syscall.ENOTDIR so that callers can test for a path that passes
+// through a file in the same way as they would for the operating system's filesystem.
+func (e *notDirError) Is(target error) bool { return target == syscall.ENOTDIR }
+
+// missingCid returns the CID of the missing block reported by err, or c if err does not report one.
+func missingCid(err error, c cid.Cid) cid.Cid {
+	var nf ipld.ErrNotFound
+	if errors.As(err, &nf) && nf.Cid.Defined() {
+		return nf.Cid
+	}
+	return c
+}
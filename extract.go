@@ -0,0 +1,217 @@
+package mfsng
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// ExtractTo writes every file and directory in the FS to the local directory destDir, creating it
+// if necessary. File content is streamed to disk so memory use does not depend on file size.
+// Permission bits and modification times are applied when they are recorded in the UnixFS data,
+// regardless of the process umask; the permissions of directories are applied once all of the FS
+// has been written, so a directory that does not permit writing can still be populated. Existing
+// files and symbolic links are always replaced rather than written through, even when their
+// content already matches. Entry names that could escape destDir, such as those
+// containing a path separator, cause ExtractTo to fail with an error wrapping fs.ErrInvalid.
+// Symbolic links are recreated as symbolic links after every file and directory has been written,
+// but only when their targets are relative, stay within destDir and do not pass through another
+// symbolic link; any other link causes ExtractTo to fail with an error wrapping fs.ErrInvalid.
+func (fsys *FS) ExtractTo(ctx context.Context, destDir string) error {
+	d, err := fsys.openDir(ctx, "extract", ".")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return newPathError("extract", destDir, d.Cid(), err)
+	}
+
+	var (
+		dirs  []extractedDir  // directories in the order their entries were completed
+		links []extractedLink // symbolic links in the order they were found
+	)
+	isLink := make(map[string]bool) // paths of the symbolic links that will be created
+	if err := fsys.walkTree(ctx, "", d, func(p string, _ *ipld.Link, e entry) error {
+		target := filepath.Join(destDir, filepath.FromSlash(p))
+		switch e := e.(type) {
+		case *Dir:
+			return extractDir(target)
+		case *File:
+			if err := extractFile(target, e); err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			return setModTime(target, &e.info)
+		case *Symlink:
+			links = append(links, extractedLink{path: p, target: target, linkTarget: e.Target()})
+			isLink[target] = true
+			return nil
+		default:
+			return fmt.Errorf("%s: unsupported entry type: %w", p, fs.ErrInvalid)
+		}
+	}, func(p string, d *Dir) error {
+		dirs = append(dirs, extractedDir{target: filepath.Join(destDir, filepath.FromSlash(p)), info: &d.info})
+		return nil
+	}); err != nil {
+		return newPathError("extract", destDir, d.Cid(), err)
+	}
+
+	// Links are created once everything else exists so that each can be checked against the tree
+	// on disk as well as the other links
+	for _, l := range links {
+		if err := checkSymlinkTarget(destDir, l.target, l.linkTarget, isLink); err != nil {
+			return newPathError("extract", destDir, d.Cid(), fmt.Errorf("%s: %w", l.path, err))
+		}
+		if err := extractSymlink(l.target, l.linkTarget); err != nil {
+			return newPathError("extract", destDir, d.Cid(), fmt.Errorf("%s: %w", l.path, err))
+		}
+	}
+
+	// Directories are completed deepest first, and their modification times are set last since
+	// writing a directory's entries changes it
+	for _, dir := range dirs {
+		if err := os.Chmod(dir.target, permOrDefault(dir.info.filemode, 0o755)); err != nil {
+			return newPathError("extract", destDir, d.Cid(), err)
+		}
+		if err := setModTime(dir.target, dir.info); err != nil {
+			return newPathError("extract", destDir, d.Cid(), err)
+		}
+	}
+	return nil
+}
+
+// extractedDir is a directory written by ExtractTo whose permissions have yet to be applied.
+type extractedDir struct {
+	target string
+	info   *FileInfo
+}
+
+// extractedLink is a symbolic link found by ExtractTo that has yet to be created.
+type extractedLink struct {
+	path       string // slash separated path of the link within the FS
+	target     string // local path of the link
+	linkTarget string // target of the link as recorded in the FS
+}
+
+// setModTime sets the modification time of the file at target if one is recorded in info.
+func setModTime(target string, info *FileInfo) error {
+	if mt := info.modtime; !mt.IsZero() {
+		if err := os.Chtimes(target, mt, mt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractDir creates a directory at target that only its owner may write to, keeping an existing
+// directory but replacing any other file, including a symbolic link to a directory.
+func extractDir(target string) error {
+	fi, err := os.Lstat(target)
+	switch {
+	case err == nil && fi.IsDir():
+		return os.Chmod(target, 0o700)
+	case err == nil:
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	return os.Mkdir(target, 0o700)
+}
+
+// extractFile writes the content of f to a new file at target, replacing any existing file.
+func extractFile(target string, f *File) error {
+	// Removing the existing file and creating exclusively ensures an existing symbolic link is
+	// never written through
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, permOrDefault(f.info.filemode, 0o644))
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteTo(out); err != nil {
+		out.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// The mode passed when creating the file is masked by the umask so a recorded mode is applied
+	// exactly, as it is for directories
+	if perm := f.info.filemode.Perm(); perm != 0 {
+		return os.Chmod(target, perm)
+	}
+	return nil
+}
+
+// checkSymlinkTarget reports an error if the symbolic link at target pointing to linkTarget could
+// resolve to a path outside root. The target is resolved one element at a time and every element
+// but the last must not be a symbolic link, either one already on disk or one in isLink that is
+// yet to be created, since a chain of links can escape root even when each stays within it.
+func checkSymlinkTarget(root string, target string, linkTarget string, isLink map[string]bool) error {
+	if strings.HasPrefix(linkTarget, "/") || filepath.IsAbs(linkTarget) {
+		return fmt.Errorf("symbolic link target %q is absolute: %w", linkTarget, fs.ErrInvalid)
+	}
+
+	elems := strings.Split(linkTarget, "/")
+	cur := filepath.Dir(target)
+	for i, elem := range elems {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+		default:
+			cur = filepath.Join(cur, elem)
+		}
+		if rel, err := filepath.Rel(root, cur); err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("symbolic link target %q is outside the destination: %w", linkTarget, fs.ErrInvalid)
+		}
+		if elem == ".." || i == len(elems)-1 {
+			continue
+		}
+		if isLink[cur] {
+			return fmt.Errorf("symbolic link target %q passes through another symbolic link: %w", linkTarget, fs.ErrInvalid)
+		}
+		if fi, err := os.Lstat(cur); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("symbolic link target %q passes through another symbolic link: %w", linkTarget, fs.ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// extractSymlink creates a symbolic link at target pointing to linkTarget, replacing any existing
+// file.
+func extractSymlink(target string, linkTarget string) error {
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(linkTarget, target)
+}
+
+// permOrDefault returns the permission bits of mode, or def if none are set.
+func permOrDefault(mode fs.FileMode, def fs.FileMode) fs.FileMode {
+	if perm := mode.Perm(); perm != 0 {
+		return perm
+	}
+	return def
+}
+
+// isLocalName reports whether name can be used as a single element of a local path without
+// referring outside of its parent directory.
+func isLocalName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, `/`+string(filepath.Separator))
+}
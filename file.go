@@ -1,13 +1,20 @@
 package mfsng
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
 	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
@@ -17,51 +24,489 @@ var (
 	_ fs.File     = (*File)(nil)
 	_ io.Seeker   = (*File)(nil)
 	_ io.WriterTo = (*File)(nil)
+	_ io.ReaderAt = (*File)(nil)
 )
 
+// A File is a UnixFS file or a block of data read as a file. The metadata accessors Name, Stat,
+// Size, Node and Cid, and RangeReader, are safe for concurrent use, including the first call that
+// loads a lazily loaded file. Read, Seek and WriteTo share the file's read position so must not be
+// called concurrently with one another.
 type File struct {
-	dr   uio.DagReader
-	ctx  context.Context // an embedded context for cancellation and deadline propogation
-	info FileInfo
+	dr     uio.DagReader
+	rd     *bufio.Reader   // optional readahead buffer over dr, set by the WithReadahead option
+	getter ipld.NodeGetter // used to create independent readers over the file, nil for files that are not UnixFS
+	ctx    context.Context // an embedded context for cancellation and deadline propogation
+	info   FileInfo
+
+	cid     cid.Cid      // cid of the root node of a lazily loaded file, undefined otherwise
+	load    func() error // loads the root node of a lazily loaded file, nil otherwise
+	once    sync.Once    // guards the call to load
+	loadErr error        // loadErr is written once by once and read-only thereafter
+
+	drOnce sync.Once // guards the creation of dr when it is not supplied on construction
+	drErr  error     // drErr is written once by drOnce and read-only thereafter
+
+	atMu      sync.Mutex      // guards atReaders
+	atReaders []uio.DagReader // idle readers retained for reuse by ReadAt
 }
 
+// maxIdleReadAtReaders is the number of idle readers a File retains for reuse by ReadAt.
+const maxIdleReadAtReaders = 4
+
+// newFile returns a File for the UnixFS file rooted at node. The size, mode and modification time
+// are taken from the node's UnixFS data and the reader over the file's content is not created until
+// it is first needed, so a File that is only stat'd reads nothing more.
 func newFile(ctx context.Context, name string, node ipld.Node, getter ipld.NodeGetter) (*File, error) {
-	dr, err := uio.NewDagReader(ctx, node, getter)
-	if err != nil {
-		return nil, fmt.Errorf("new dag reader: %w", err)
+	info := FileInfo{
+		name: name,
+		node: node,
+	}
+
+	switch tnode := node.(type) {
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(tnode.Data())
+		if err != nil {
+			return nil, fmt.Errorf("unixfs from node: %w", err)
+		}
+		info.size = int64(fsn.FileSize())
+		info.filemode = unixfsMode(fsn)
+		info.modtime = fsn.ModTime()
+	case *merkledag.RawNode:
+		info.size = int64(len(tnode.RawData()))
+		info.filemode = defaultFilePerm
+	default:
+		return nil, fmt.Errorf("unexpected node type %T: %w", node, fs.ErrInvalid)
 	}
 
 	return &File{
-		dr:  dr,
+		getter: getter,
+		ctx:    ctx,
+		info:   info,
+	}, nil
+}
+
+// newFileLazy returns a File for the node with the supplied CID. The node is not loaded until the file
+// is first read or stat'd, so creating the File does not touch the getter.
+func newFileLazy(ctx context.Context, name string, c cid.Cid, getter ipld.NodeGetter) *File {
+	f := &File{
 		ctx: ctx,
+		cid: c,
 		info: FileInfo{
-			name:     name,
-			size:     int64(dr.Size()),
-			filemode: dr.FileMode() & os.ModeType,
-			modtime:  dr.ModTime(),
-			node:     node,
+			name: name,
 		},
-	}, nil
+	}
+	f.load = func() error {
+		node, err := getter.Get(ctx, c)
+		if err != nil {
+			if errors.Is(err, ipld.ErrNotFound{}) {
+				return newPathError("open", name, missingCid(err, c), fs.ErrNotExist)
+			}
+			return newPathError("open", name, c, fmt.Errorf("get node: %w", err))
+		}
+
+		e, err := newEntry(ctx, name, node, getter)
+		if err != nil {
+			return newPathError("open", name, c, err)
+		}
+		loaded, ok := e.(*File)
+		if !ok {
+			e.Close()
+			return newPathError("open", name, c, fs.ErrInvalid)
+		}
+
+		f.dr = loaded.dr
+		f.getter = loaded.getter
+		// Copy only the loaded fields, the name is set on construction and may be read without
+		// waiting for the load
+		f.info.filemode = loaded.info.filemode
+		f.info.size = loaded.info.size
+		f.info.modtime = loaded.info.modtime
+		f.info.node = loaded.info.node
+		f.info.mimeType = loaded.info.mimeType
+		f.info.notUnixFS = loaded.info.notUnixFS
+		return nil
+	}
+	return f
+}
+
+// ensureLoaded loads the root node of a lazily loaded file, returning any error encountered.
+func (f *File) ensureLoaded() error {
+	f.once.Do(func() {
+		if f.load != nil {
+			f.loadErr = f.load()
+		}
+	})
+	return f.loadErr
+}
+
+// ensureReader loads a lazily loaded file and creates the reader over the file's content if it has
+// not already been created, returning any error encountered.
+func (f *File) ensureReader() error {
+	if err := f.ensureLoaded(); err != nil {
+		return err
+	}
+	f.drOnce.Do(func() {
+		if f.dr != nil {
+			return
+		}
+		dr, err := uio.NewDagReader(f.ctx, f.info.node, f.getter)
+		if err != nil {
+			f.drErr = fmt.Errorf("new dag reader: %w", err)
+			return
+		}
+		f.dr = dr
+	})
+	return f.drErr
+}
+
+// newBlockFile returns a File whose content is the raw data of a node that is not a UnixFS node.
+func newBlockFile(ctx context.Context, name string, node ipld.Node) *File {
+	data := node.RawData()
+	return &File{
+		dr:  &blockReader{Reader: bytes.NewReader(data)},
+		ctx: ctx,
+		info: FileInfo{
+			name:      name,
+			size:      int64(len(data)),
+			node:      node,
+			notUnixFS: true,
+		},
+	}
 }
 
 // Stat returns a FileInfo describing the file.
 func (f *File) Stat() (fs.FileInfo, error) {
+	if err := f.ensureLoaded(); err != nil {
+		return nil, err
+	}
 	return &f.info, nil
 }
 
 func (f *File) Read(buf []byte) (int, error) {
+	if err := f.ensureReader(); err != nil {
+		return 0, err
+	}
+	if f.rd != nil {
+		return f.rd.Read(buf)
+	}
 	return f.dr.CtxReadFull(f.ctx, buf)
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	return f.dr.Seek(offset, whence)
+	if err := f.ensureReader(); err != nil {
+		return 0, err
+	}
+	if f.rd == nil {
+		return f.dr.Seek(offset, whence)
+	}
+
+	// The underlying reader is ahead of the caller by the amount of buffered data
+	if whence == io.SeekCurrent {
+		offset -= int64(f.rd.Buffered())
+	}
+	n, err := f.dr.Seek(offset, whence)
+	if err != nil {
+		return n, err
+	}
+	f.rd.Reset(&dagReadFull{ctx: f.ctx, dr: f.dr})
+	return n, nil
 }
 
 func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if err := f.ensureReader(); err != nil {
+		return 0, err
+	}
+	if f.rd != nil {
+		return f.rd.WriteTo(w)
+	}
 	return f.dr.WriteTo(w)
 }
 
+// RangeReader returns a reader over length bytes of the file's content starting at the byte offset
+// start, suitable for serving an HTTP range request. The returned reader seeks directly to the start
+// of the range without reading the content before it, and is independent of the file's own read
+// position. The resources held by the reader are released once the whole range has been read or a
+// read fails, and Close releases them early. A range that does not lie within the file results in
+// ErrInvalidRange.
+func (f *File) RangeReader(start, length int64) (io.ReadCloser, error) {
+	if err := f.ensureLoaded(); err != nil {
+		return nil, err
+	}
+	if start < 0 || length < 0 || start > f.info.size || length > f.info.size-start {
+		return nil, fmt.Errorf("range %d-%d of file with size %d: %w", start, start+length, f.info.size, ErrInvalidRange)
+	}
+
+	dr, err := f.newReader()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dr.Seek(start, io.SeekStart); err != nil {
+		dr.Close()
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	return &rangeReader{ctx: f.ctx, dr: dr, remaining: length}, nil
+}
+
+// ReadAt reads len(p) bytes of the file's content starting at the byte offset off, following the
+// io.ReaderAt contract. Each call uses a reader over the content that no other call is using, so
+// ReadAt does not affect the file's read position and may be called concurrently, including
+// alongside Read. A few idle readers are retained between calls to avoid recreating them.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.info.name, Err: fmt.Errorf("negative offset: %w", fs.ErrInvalid)}
+	}
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+
+	f.atMu.Lock()
+	var dr uio.DagReader
+	if n := len(f.atReaders); n > 0 {
+		dr = f.atReaders[n-1]
+		f.atReaders = f.atReaders[:n-1]
+	}
+	f.atMu.Unlock()
+
+	if dr == nil {
+		var err error
+		dr, err = f.newReader()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := dr.Seek(off, io.SeekStart); err != nil {
+		dr.Close()
+		return 0, fmt.Errorf("seek: %w", err)
+	}
+
+	n, err := io.ReadFull(&dagReadFull{ctx: f.ctx, dr: dr}, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	if err != nil && err != io.EOF {
+		dr.Close()
+		return n, err
+	}
+
+	f.atMu.Lock()
+	if len(f.atReaders) < maxIdleReadAtReaders {
+		f.atReaders = append(f.atReaders, dr)
+		dr = nil
+	}
+	f.atMu.Unlock()
+	if dr != nil {
+		dr.Close()
+	}
+	return n, err
+}
+
+// newReader returns a DagReader over the file's content that is independent of the file's reader.
+func (f *File) newReader() (uio.DagReader, error) {
+	if f.info.notUnixFS {
+		return &blockReader{Reader: bytes.NewReader(f.info.node.RawData())}, nil
+	}
+	dr, err := uio.NewDagReader(f.ctx, f.info.node, f.getter)
+	if err != nil {
+		return nil, fmt.Errorf("new dag reader: %w", err)
+	}
+	return dr, nil
+}
+
+// SameContent reports whether the files a and b have the same content. Files with the same CID
+// are known to have the same content without reading either of them. Otherwise the files are
+// compared byte by byte, since the same content may be encoded in different DAGs, for example when
+// using a different chunker. The read positions of the files are not affected.
+func SameContent(a, b *File) (bool, error) {
+	if err := a.ensureLoaded(); err != nil {
+		return false, err
+	}
+	if err := b.ensureLoaded(); err != nil {
+		return false, err
+	}
+
+	if a.Cid().Equals(b.Cid()) {
+		return true, nil
+	}
+	if a.info.size != b.info.size {
+		return false, nil
+	}
+
+	ra, err := a.newReader()
+	if err != nil {
+		return false, err
+	}
+	defer ra.Close()
+	rb, err := b.newReader()
+	if err != nil {
+		return false, err
+	}
+	defer rb.Close()
+
+	// The files have the same size so reach the end of their content together
+	bufa := make([]byte, 32*1024)
+	bufb := make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(&dagReadFull{ctx: a.ctx, dr: ra}, bufa)
+		if erra != nil && !isEOF(erra) {
+			return false, erra
+		}
+		nb, errb := io.ReadFull(&dagReadFull{ctx: b.ctx, dr: rb}, bufb)
+		if errb != nil && !isEOF(errb) {
+			return false, errb
+		}
+		if !bytes.Equal(bufa[:na], bufb[:nb]) {
+			return false, nil
+		}
+		if erra != nil {
+			return true, nil
+		}
+	}
+}
+
+// isEOF reports whether err indicates the end of a read by io.ReadFull.
+func isEOF(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// gzipMagic is the header that begins every gzip stream.
+var gzipMagic = [2]byte{0x1f, 0x8b}
+
+// DecompressedReader returns a reader that decompresses the file's content when it is gzip compressed,
+// which is detected by peeking at the bytes at the file's current position. Content that is not gzip
+// compressed is returned unchanged. Other compression formats, such as zstd, are not detected.
+// Closing the returned reader does not close the file.
+func (f *File) DecompressedReader() (io.ReadCloser, error) {
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+
+	var magic [2]byte
+	n, err := io.ReadFull(f, magic[:])
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+
+	if n < len(magic) || magic != gzipMagic {
+		return io.NopCloser(f), nil
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("new gzip reader: %w", err)
+	}
+	return zr, nil
+}
+
+// Size returns the length of the file's content in bytes, as recorded in its root node, without
+// reading any content. It returns 0 if a lazily loaded file cannot be loaded.
+func (f *File) Size() int64 {
+	if err := f.ensureLoaded(); err != nil {
+		return 0
+	}
+	return f.info.size
+}
+
+// Node returns the root node of the file's DAG, which may be linked into another UnixFS directory
+// without reimporting the file's content. It returns nil if a lazily loaded file cannot be loaded.
+func (f *File) Node() ipld.Node {
+	if err := f.ensureLoaded(); err != nil {
+		return nil
+	}
+	return f.info.node
+}
+
+// IsInline reports whether all of the file's content is held in its root node rather than being
+// spread across multiple blocks. It returns false if a lazily loaded file cannot be loaded.
+func (f *File) IsInline() bool {
+	if err := f.ensureLoaded(); err != nil {
+		return false
+	}
+	return len(f.info.node.Links()) == 0
+}
+
+// BlockCids returns the CIDs of the leaf blocks holding the file's content, in file order. If
+// intermediate is true the CIDs of the nodes linking the leaves together are included too, each
+// preceding the blocks it links to, starting with the file's root node. Leaves stored as raw
+// blocks are identified by their CID alone and are never loaded, but intermediate nodes and leaves
+// stored as dag-pb nodes must be loaded to discover whether they link to further blocks. A node
+// that links to further blocks is never listed as a leaf, even when it also carries data of its
+// own, since that data is not part of the file's content: readers of UnixFS files, including Read,
+// skip the data of internal nodes.
+func (f *File) BlockCids(intermediate bool) ([]cid.Cid, error) {
+	if err := f.ensureLoaded(); err != nil {
+		return nil, err
+	}
+	if f.info.notUnixFS {
+		return []cid.Cid{f.info.node.Cid()}, nil
+	}
+
+	var cids []cid.Cid
+	if err := f.appendBlockCids(f.info.node, intermediate, &cids); err != nil {
+		return nil, newPathError("blockcids", f.info.name, f.info.node.Cid(), err)
+	}
+	return cids, nil
+}
+
+// appendBlockCids appends the CIDs of the blocks in the DAG rooted at node to cids.
+func (f *File) appendBlockCids(node ipld.Node, intermediate bool, cids *[]cid.Cid) error {
+	links := node.Links()
+	if len(links) == 0 {
+		*cids = append(*cids, node.Cid())
+		return nil
+	}
+	if intermediate {
+		*cids = append(*cids, node.Cid())
+	}
+
+	for _, l := range links {
+		if l.Cid.Type() != cid.DagProtobuf {
+			// A raw block is always a leaf
+			*cids = append(*cids, l.Cid)
+			continue
+		}
+		child, err := l.GetNode(f.ctx, f.getter)
+		if err != nil {
+			return fmt.Errorf("get node: %w", err)
+		}
+		if err := f.appendBlockCids(child, intermediate, cids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *File) Close() error {
+	// A lazily loaded file that is closed before use is never loaded
+	f.once.Do(func() {
+		if f.load != nil {
+			f.loadErr = fs.ErrClosed
+		}
+	})
+	f.drOnce.Do(func() {
+		if f.dr == nil {
+			f.drErr = fs.ErrClosed
+		}
+	})
+
+	f.atMu.Lock()
+	for _, dr := range f.atReaders {
+		dr.Close()
+	}
+	f.atReaders = nil
+	f.atMu.Unlock()
+
+	if f.dr == nil {
+		return nil
+	}
 	return f.dr.Close()
 }
 
@@ -69,16 +514,91 @@ func (f *File) Name() string               { return f.info.name }
 func (f *File) IsDir() bool                { return false }
 func (f *File) Info() (fs.FileInfo, error) { return f.Stat() }
 func (f *File) Type() fs.FileMode          { return fs.FileMode(0) }
-func (f *File) Cid() cid.Cid               { return f.info.node.Cid() }
+func (f *File) fileInfo() *FileInfo        { return &f.info }
+
+// Cid returns the CID of the file's root node. It does not load a lazily loaded file. It returns
+// cid.Undef if the file has no root node.
+func (f *File) Cid() cid.Cid {
+	if f.cid.Defined() {
+		return f.cid
+	}
+	return f.info.Cid()
+}
+
+// dagReadFull adapts a DagReader so that reads use the supplied context.
+type dagReadFull struct {
+	ctx context.Context
+	dr  uio.DagReader
+}
+
+func (r *dagReadFull) Read(buf []byte) (int, error)       { return r.dr.CtxReadFull(r.ctx, buf) }
+func (r *dagReadFull) WriteTo(w io.Writer) (int64, error) { return r.dr.WriteTo(w) }
+
+// rangeReader reads a limited number of bytes from a DagReader, closing the DagReader once they
+// have all been read or a read fails.
+type rangeReader struct {
+	ctx       context.Context
+	dr        uio.DagReader // nil once closed
+	remaining int64
+}
+
+func (r *rangeReader) Read(buf []byte) (int, error) {
+	if r.dr == nil || r.remaining <= 0 {
+		r.Close()
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > r.remaining {
+		buf = buf[:r.remaining]
+	}
+	n, err := r.dr.CtxReadFull(r.ctx, buf)
+	r.remaining -= int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	if err != nil || r.remaining == 0 {
+		r.Close()
+	}
+	return n, err
+}
+
+func (r *rangeReader) Close() error {
+	if r.dr == nil {
+		return nil
+	}
+	err := r.dr.Close()
+	r.dr = nil
+	return err
+}
+
+var _ uio.DagReader = (*blockReader)(nil)
+
+// blockReader is a DagReader over the raw data of a single block.
+type blockReader struct {
+	*bytes.Reader
+}
+
+func (r *blockReader) Size() uint64          { return uint64(r.Reader.Size()) }
+func (r *blockReader) FileMode() os.FileMode { return 0 }
+func (r *blockReader) ModTime() time.Time    { return time.Time{} }
+func (r *blockReader) Close() error          { return nil }
+
+func (r *blockReader) CtxReadFull(ctx context.Context, buf []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(buf)
+}
 
 var _ fs.FileInfo = (*FileInfo)(nil)
 
 type FileInfo struct {
-	name     string
-	filemode fs.FileMode // just the file type bits
-	size     int64
-	modtime  time.Time
-	node     ipld.Node
+	name      string
+	filemode  fs.FileMode // type and permission bits
+	size      int64
+	modtime   time.Time
+	node      ipld.Node
+	mimeType  string // mime type from a wrapping metadata node, if any
+	notUnixFS bool   // node is not a UnixFS node, its raw block data is presented as the file content
 }
 
 // Name returns the base name of the file or directory.
@@ -86,7 +606,10 @@ func (f *FileInfo) Name() string {
 	return f.name
 }
 
-// Size returns the length in bytes of a file or the size in bytes of the underlying node for a directory.
+// Size returns the length in bytes of a file. For a directory it returns the cumulative size of the
+// directory's DAG, which is the length of the directory's serialized node plus the sizes recorded in
+// its links for each of the entries it contains. This depends only on the content of the directory
+// so is stable for a given CID.
 func (f *FileInfo) Size() int64 {
 	return f.size
 }
@@ -111,7 +634,47 @@ func (f *FileInfo) Sys() interface{} {
 	return f.node
 }
 
-// Cid returns the CID of the file or directory's root node.
+// Cid returns the CID of the file or directory's root node, or cid.Undef if there is no root node.
 func (f *FileInfo) Cid() cid.Cid {
+	if f.node == nil {
+		return cid.Undef
+	}
 	return f.node.Cid()
 }
+
+// MimeType returns the mime type recorded by a UnixFS metadata node that wraps the file or directory,
+// or an empty string if there is none.
+func (f *FileInfo) MimeType() string {
+	return f.mimeType
+}
+
+// IsUnixFS reports whether the file or directory is a UnixFS node. A directory may link to other kinds
+// of IPLD node, such as dag-cbor documents. These are presented as files whose content is the raw data
+// of the node's block.
+func (f *FileInfo) IsUnixFS() bool {
+	return !f.notUnixFS
+}
+
+// Permissions used by UnixFS for nodes that do not record a mode.
+const (
+	defaultFilePerm fs.FileMode = 0o644
+	defaultDirPerm  fs.FileMode = 0o755
+)
+
+// unixfsMode returns the permission bits recorded in the node's UnixFS data, or the UnixFS default
+// for the node's type if none are recorded. UnixFS records modes using the POSIX layout so the
+// setuid, setgid and sticky bits are converted to their fs.FileMode equivalents.
+func unixfsMode(fsn *unixfs.FSNode) fs.FileMode {
+	m := fsn.FileMode()
+	mode := fs.FileMode(m & 0o777)
+	if m&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
@@ -1,55 +1,248 @@
 package mfsng
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/url"
 	"os"
-	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unicode/utf8"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/ipfs/boxo/ipld/merkledag"
 	"github.com/ipfs/boxo/ipld/unixfs"
 	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	ipath "github.com/ipfs/boxo/path"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 )
 
 var (
 	// Supported interfaces for FS
-	_ fs.FS        = (*FS)(nil)
-	_ fs.ReadDirFS = (*FS)(nil)
-	_ fs.SubFS     = (*FS)(nil)
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.SubFS      = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
 )
 
+// An FS is a read-only filesystem over a UnixFS DAG. An FS must always be used through the pointer
+// returned by ReadFS or by one of the methods that derive a new FS, such as WithContext or Sub, and
+// must not be copied by value. Filesystems derived from one another share immutable state and
+// caches that are safe for concurrent use, but each has its own closed state, and one derived
+// from a closed FS starts closed. go vet reports
+// copies of an FS value. The methods of an FS may be called concurrently.
 type FS struct {
-	udir   uio.Directory
+	root   ipld.Node     // the root node of the directory, kept since a sharded udir cannot produce it without writing
+	udir   uio.Directory // the root directory, nil if it is sharded since a shard caches the nodes it loads without locking
 	getter ipld.NodeGetter
-	ctx    context.Context // an embedded context for cancellation and deadline propogation, can be overridden by WithContext method
+	ctx    context.Context           // an embedded context for cancellation and deadline propogation, can be overridden by WithContext method
+	closed atomic.Bool               // set by Close, and on filesystems derived from this one after Close
+	links  atomic.Pointer[lru.Cache] // sorted links of sharded directories keyed by cid, shared with filesystems derived from this one, nil once closed
+
+	opts fsOptions // options set using With methods, copied to filesystems derived from this one
+}
+
+type fsOptions struct {
+	maxPathDepth   int  // maximum number of elements in a path, DefaultMaxPathDepth if zero
+	maxSymlinkHops int  // maximum number of symbolic links followed in a path, DefaultMaxSymlinkHops if zero
+	urlUnescape    bool // match path elements that are URL escaped when there is no literal match
 }
 
+// DefaultMaxPathDepth is the maximum number of elements a path may contain unless changed using
+// WithMaxPathDepth.
+const DefaultMaxPathDepth = 1024
+
+// DefaultMaxSymlinkHops is the maximum number of symbolic links that are followed while resolving a
+// single path unless changed using WithMaxSymlinkHops.
+const DefaultMaxSymlinkHops = 40
+
+// capabilitySampleLinks is the number of the root's links that Capabilities loads.
+const capabilitySampleLinks = 8
+
+// shardLinksCacheSize is the number of sharded directories whose sorted links are retained by ReadDir.
+const shardLinksCacheSize = 16
+
 // ReadFS returns a read-only filesystem. It expects the supplied node to be the root of a UnixFS merkledag.
+//
+// Every node other than the root is obtained from getter, which is the extension point for
+// customising where block data is stored. The FS makes no assumption that blocks are held in
+// memory, so a getter may, for example, read block data from positions within external files
+// as the IPFS filestore does.
 func ReadFS(node ipld.Node, getter ipld.NodeGetter) (*FS, error) {
 	udir, err := uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(getter), node)
 	if err != nil {
+		if errors.Is(err, uio.ErrNotADir) {
+			return nil, errNotDir(node)
+		}
 		return nil, fmt.Errorf("new directory from node: %w", err)
 	}
+	if isShardNode(node) {
+		udir = nil
+	}
+
+	// A node that was built rather than decoded encodes itself and caches the result the first
+	// time its CID is requested, so do that now before the node is shared between goroutines
+	node.Cid()
+
+	links, err := lru.New(shardLinksCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("new links cache: %w", err)
+	}
 
-	return &FS{
+	fsys := &FS{
+		root:   node,
 		udir:   udir,
 		getter: getter,
 		ctx:    context.Background(),
-	}, nil
+	}
+	fsys.links.Store(links)
+	return fsys, nil
+}
+
+// ReadFSMulti returns a read-only filesystem rooted at the UnixFS directory with the supplied CID.
+// Nodes are requested from each getter in turn until one of them has the node, so a local cache
+// can be consulted before a slower network store. A node that is missing from every getter is
+// reported as fs.ErrNotExist when resolving a path.
+func ReadFSMulti(root cid.Cid, getters ...ipld.NodeGetter) (*FS, error) {
+	getter := fallbackGetter(getters)
+	node, err := getter.Get(context.Background(), root)
+	if err != nil {
+		return nil, fmt.Errorf("get root node: %w", err)
+	}
+	return ReadFS(node, getter)
+}
+
+// A Blockstore retrieves blocks by CID. It is satisfied by the blockstores in github.com/ipfs/boxo/blockstore,
+// which report a missing block with an error wrapping ipld.ErrNotFound.
+type Blockstore interface {
+	Get(ctx context.Context, c cid.Cid) (blocks.Block, error)
+}
+
+// ReadFSFromBlockstore returns a read-only filesystem rooted at the UnixFS directory with the
+// supplied CID, whose blocks are read from bs and decoded as they are needed. The root node is
+// loaded using ctx, which also becomes the context of the FS as if set using WithContext.
+func ReadFSFromBlockstore(ctx context.Context, bs Blockstore, root cid.Cid) (*FS, error) {
+	return ReadFSFromCid(ctx, root, storeGetter{bs: bs})
+}
+
+// ReadFSFromCid returns a read-only filesystem rooted at the UnixFS directory with the supplied
+// CID, loading the root node from getter using ctx. ctx also becomes the context of the FS as if
+// set using WithContext. An error wrapping fs.ErrInvalid is returned if the root is not a
+// UnixFS directory.
+func ReadFSFromCid(ctx context.Context, c cid.Cid, getter ipld.NodeGetter) (*FS, error) {
+	node, err := getter.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("get root node: %w", err)
+	}
+	fsys, err := ReadFS(node, getter)
+	if err != nil {
+		return nil, fmt.Errorf("root %s: %w", c, err)
+	}
+	fsys.ctx = ctx
+	return fsys, nil
 }
 
 // WithContext returns an FS using the supplied context
 func (fsys *FS) WithContext(ctx context.Context) fs.FS {
-	return &FS{
-		udir:   fsys.udir,
-		getter: fsys.getter,
+	return fsys.derive(fsys.root, fsys.udir, fsys.getter, ctx, fsys.opts)
+}
+
+// WithMaxPathDepth returns an FS that rejects paths with more than n elements, guarding against
+// resource exhaustion when resolving paths in deeply nested DAGs. A path that exceeds the limit
+// results in an error wrapping fs.ErrInvalid. The default limit is DefaultMaxPathDepth.
+func (fsys *FS) WithMaxPathDepth(n int) *FS {
+	opts := fsys.opts
+	opts.maxPathDepth = n
+	return fsys.withOptions(opts)
+}
+
+// WithMaxSymlinkHops returns an FS that follows at most n symbolic links while resolving a single
+// path, guarding against cycles of links. A path that needs more results in an error wrapping
+// fs.ErrInvalid. The default limit is DefaultMaxSymlinkHops.
+func (fsys *FS) WithMaxSymlinkHops(n int) *FS {
+	opts := fsys.opts
+	opts.maxSymlinkHops = n
+	return fsys.withOptions(opts)
+}
+
+// WithURLUnescape returns an FS that also matches URL escaped path elements, so that a path
+// received by an HTTP gateway such as "my%20file" opens an entry named "my file". Each element is
+// first matched literally and is only unescaped when there is no entry with the literal name, so
+// names that contain a '%' can still be opened exactly as they are named. An element is unescaped
+// at most once.
+func (fsys *FS) WithURLUnescape() *FS {
+	opts := fsys.opts
+	opts.urlUnescape = true
+	return fsys.withOptions(opts)
+}
+
+// withOptions returns a copy of the FS that uses the supplied options.
+func (fsys *FS) withOptions(opts fsOptions) *FS {
+	return fsys.derive(fsys.root, fsys.udir, fsys.getter, fsys.ctx, opts)
+}
+
+// derive returns a new FS that shares the links cache of the FS. The new FS is closed if the FS
+// has been closed, so a closed FS cannot be reopened by deriving another from it.
+func (fsys *FS) derive(root ipld.Node, udir uio.Directory, getter ipld.NodeGetter, ctx context.Context, opts fsOptions) *FS {
+	d := &FS{
+		root:   root,
+		udir:   udir,
+		getter: getter,
 		ctx:    ctx,
+		opts:   opts,
 	}
+	d.links.Store(fsys.links.Load())
+	if fsys.closed.Load() {
+		d.closed.Store(true)
+	}
+	return d
+}
+
+// WithVerifyBlocks returns an FS that checks every node it loads, including the root node, by
+// hashing the node's data and comparing it with the node's CID. This guards against corrupted or
+// malicious block stores at the cost of hashing each block. A node that fails verification results
+// in an error wrapping ErrBlockVerification.
+func (fsys *FS) WithVerifyBlocks() (*FS, error) {
+	getter := &verifyingGetter{getter: fsys.getter}
+	if err := verifyNode(fsys.root.Cid(), fsys.root); err != nil {
+		return nil, err
+	}
+
+	vfs, err := ReadFS(fsys.root, getter)
+	if err != nil {
+		return nil, err
+	}
+	vfs.ctx = fsys.ctx
+	vfs.opts = fsys.opts
+	if fsys.closed.Load() {
+		vfs.Close()
+	}
+	return vfs, nil
+}
+
+// Close releases any resources held by the FS. After Close has been called the operations that
+// read from the tree, such as Open, Stat, ReadDir, Sub, RootLinks, Count, Manifest and WriteTar,
+// return an error wrapping fs.ErrClosed, and Walk passes that error to its function for the root.
+// Glob reports no matches without an error, as fs.Glob does for a pattern that cannot be read, and
+// Capabilities reports no features. ETag and RootCid describe the root node, which is already held,
+// so they continue to work. Files and directories that are already open, and filesystems previously
+// returned by WithContext or Sub, are not affected, but a filesystem derived from the FS after Close,
+// for example by WithContext or WithMaxPathDepth, is closed too. Close releases the FS's reference
+// to the cache of sharded directory links.
+func (fsys *FS) Close() error {
+	fsys.closed.Store(true)
+	fsys.links.Store(nil)
+	return nil
 }
 
 func (fsys *FS) context() context.Context {
@@ -59,63 +252,209 @@ func (fsys *FS) context() context.Context {
 	return fsys.ctx
 }
 
+// Open opens the named file or directory. If the path does not exist the error wraps
+// fs.ErrNotExist. If an element of the path other than the last is a file rather than a directory
+// the error wraps both syscall.ENOTDIR and, for compatibility, fs.ErrInvalid.
 func (fsys *FS) Open(path string) (fs.File, error) {
-	if !fs.ValidPath(path) {
+	return fsys.OpenFile(path)
+}
+
+// An OpenOption configures how OpenFile opens a file.
+type OpenOption func(*openConfig)
+
+type openConfig struct {
+	ctx       context.Context
+	readahead int
+	offset    int64
+}
+
+// WithReadContext sets the context used for resolving the path and for all subsequent reads
+// from the opened file, in place of the FS's context.
+func WithReadContext(ctx context.Context) OpenOption {
+	return func(c *openConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithReadahead buffers reads from the opened file so that at least n bytes are requested from
+// the underlying DAG at a time. It has no effect when opening a directory.
+func WithReadahead(n int) OpenOption {
+	return func(c *openConfig) {
+		c.readahead = n
+	}
+}
+
+// WithOffset positions the opened file at the byte offset n. Using a non-zero offset when opening
+// a directory is an error.
+func WithOffset(n int64) OpenOption {
+	return func(c *openConfig) {
+		c.offset = n
+	}
+}
+
+// OpenFile opens the named file or directory using the supplied options.
+func (fsys *FS) OpenFile(path string, opts ...OpenOption) (fs.File, error) {
+	cfg := openConfig{
+		ctx: fsys.context(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := ValidatePath(path); err != nil {
 		return nil, &fs.PathError{
 			Op:   "open",
 			Path: path,
-			Err:  fs.ErrInvalid,
+			Err:  err,
 		}
 	}
 
 	if path == "." {
 		path = ""
 	}
-	node, nodeName, err := fsys.locateNode(path)
+	node, nodeName, err := fsys.locateNode(cfg.ctx, "open", path)
 	if err != nil {
-		return nil, &fs.PathError{
-			Op:   "open",
-			Path: path,
-			Err:  err,
+		return nil, err
+	}
+
+	e, err := newEntry(cfg.ctx, nodeName, node, fsys.getter)
+	if err != nil {
+		return nil, newPathError("open", path, node.Cid(), err)
+	}
+
+	f, ok := e.(*File)
+	if !ok {
+		if cfg.offset != 0 {
+			return nil, newPathError("open", path, node.Cid(), fs.ErrInvalid)
 		}
+		return e, nil
 	}
 
-	switch tnode := node.(type) {
-	case *merkledag.ProtoNode:
-		fsn, err := unixfs.FSNodeFromBytes(tnode.Data())
-		if err != nil {
-			return nil, &fs.PathError{
-				Op:   "open",
-				Path: path,
-				Err:  err,
-			}
+	if cfg.offset != 0 {
+		if _, err := f.Seek(cfg.offset, io.SeekStart); err != nil {
+			return nil, newPathError("open", path, node.Cid(), fmt.Errorf("seek: %w", err))
+		}
+	}
+	if cfg.readahead > 0 {
+		if err := f.ensureReader(); err != nil {
+			return nil, newPathError("open", path, node.Cid(), err)
 		}
+		f.rd = bufio.NewReaderSize(&dagReadFull{ctx: f.ctx, dr: f.dr}, cfg.readahead)
+	}
 
-		switch fsn.Type() {
-		case unixfs.TDirectory, unixfs.THAMTShard:
-			return newDir(fsys.context(), nodeName, tnode, fsys.getter)
+	return f, nil
+}
 
-		case unixfs.TFile:
-			return newFile(fsys.context(), nodeName, tnode, fsys.getter)
+// openManyConcurrency is the maximum number of paths opened concurrently by OpenMany.
+const openManyConcurrency = 8
 
-		case unixfs.TRaw:
-			// TODO
-		case unixfs.TSymlink:
-			// TODO
+// OpenMany opens each of the named files or directories, resolving several paths concurrently so
+// the latency of fetching nodes from a slow store overlaps. The returned slices are parallel to
+// paths: for each path either the file or the error is set. The supplied context is used for
+// resolving the paths and for all subsequent reads from the opened files.
+func (fsys *FS) OpenMany(ctx context.Context, paths []string) ([]fs.File, []error) {
+	files := make([]fs.File, len(paths))
+	errs := make([]error, len(paths))
+
+	sem := make(chan struct{}, openManyConcurrency)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			files[i], errs[i] = fsys.OpenFile(path, WithReadContext(ctx))
+		}(i, path)
+	}
+	wg.Wait()
+
+	return files, errs
+}
+
+// OpenStat opens the named file or directory and returns it along with its FileInfo, resolving
+// the path once. The FileInfo is the same value that the returned file's Stat method reports.
+func (fsys *FS) OpenStat(path string) (fs.File, fs.FileInfo, error) {
+	f, err := fsys.OpenFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.(entry).fileInfo(), nil
+}
+
+// maxReadFilePrealloc is the largest buffer ReadFile allocates before reading a file.
+const maxReadFilePrealloc = 64 << 20
+
+// ReadFile reads the named file and returns its contents. The buffer holding the contents is
+// allocated once using the size recorded in the file's UnixFS data, avoiding the repeated growth
+// of a buffer that occurs when reading a file of unknown size.
+func (fsys *FS) ReadFile(path string) ([]byte, error) {
+	f, err := fsys.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, ok := f.(*File)
+	if !ok {
+		return nil, newPathError("readfile", path, f.(entry).fileInfo().Cid(), fmt.Errorf("not a file: %w", fs.ErrInvalid))
+	}
+
+	// The size is taken from the file's data so is capped to avoid a corrupt or malicious node
+	// causing an enormous allocation; the buffer grows as usual if the file is larger
+	size := file.Size()
+	if size < 0 || size > maxReadFilePrealloc {
+		size = maxReadFilePrealloc
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := file.WriteTo(buf); err != nil {
+		return nil, newPathError("readfile", path, file.Cid(), fmt.Errorf("read: %w", err))
+	}
+	return buf.Bytes(), nil
+}
+
+// Stat returns a FileInfo describing the named file or directory. Only the nodes along the path
+// are loaded; the size, mode and modification time of a file are taken from its UnixFS data
+// without creating a reader or loading any of the file's content.
+func (fsys *FS) Stat(path string) (fs.FileInfo, error) {
+	if err := ValidatePath(path); err != nil {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: path,
+			Err:  err,
 		}
 	}
 
-	return nil, &fs.PathError{
-		Op:   "open",
-		Path: path,
-		Err:  fs.ErrInvalid,
+	if path == "." {
+		path = ""
+	}
+	node, nodeName, err := fsys.locateNode(fsys.context(), "stat", path)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := newEntry(fsys.context(), nodeName, node, fsys.getter)
+	if err != nil {
+		return nil, newPathError("stat", path, node.Cid(), err)
+	}
+	defer e.Close()
+	return e.fileInfo(), nil
+}
+
+// OpenFileCid returns a File for the UnixFS file with the supplied CID, reporting name as its name.
+// The file's root node is not loaded until the file is first read or stat'd, so creating a File
+// that is never used does not request any nodes from the FS's getter. Any error encountered while
+// loading the node is returned by the first operation on the file.
+func (fsys *FS) OpenFileCid(c cid.Cid, name string) (*File, error) {
+	if fsys.closed.Load() {
+		return nil, newPathError("open", name, c, fs.ErrClosed)
 	}
+	return newFileLazy(fsys.context(), name, c, fsys.getter), nil
 }
 
 // Sub returns an FS corresponding to the subtree rooted at dir.
 func (fsys *FS) Sub(path string) (fs.FS, error) {
-	node, _, err := fsys.locateNode(path)
-	if err != nil {
+	if err := ValidatePath(path); err != nil {
 		return nil, &fs.PathError{
 			Op:   "sub",
 			Path: path,
@@ -123,153 +462,737 @@ func (fsys *FS) Sub(path string) (fs.FS, error) {
 		}
 	}
 
+	if path == "." {
+		path = ""
+	}
+	node, _, err := fsys.locateNode(fsys.context(), "sub", path)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := unwrapMetadata(fsys.context(), node, fsys.getter)
+	if err != nil {
+		return nil, newPathError("sub", path, node.Cid(), err)
+	}
+	node = wrapped
+
 	udir, err := uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(fsys.getter), node)
 	if err != nil {
 		if errors.Is(err, uio.ErrNotADir) {
-			return nil, &fs.PathError{
-				Op:   "sub",
-				Path: path,
-				Err:  fs.ErrInvalid,
-			}
-		}
-		return nil, &fs.PathError{
-			Op:   "sub",
-			Path: path,
-			Err:  fmt.Errorf("new directory from node: %w", err),
+			return nil, newPathError("sub", path, node.Cid(), errNotDir(node))
 		}
+		return nil, newPathError("sub", path, node.Cid(), fmt.Errorf("new directory from node: %w", err))
+	}
+	if isShardNode(node) {
+		udir = nil
 	}
 
-	return &FS{
-		getter: fsys.getter,
-		udir:   udir,
-		ctx:    fsys.context(),
-	}, nil
+	return fsys.derive(node, udir, fsys.getter, fsys.context(), fsys.opts), nil
 }
 
 // ReadDir reads the named directory
 // and returns a list of directory entries sorted by filename.
+// Each entry is a *DirEntry whose node is not loaded until its type or info is requested.
 func (fsys *FS) ReadDir(path string) ([]fs.DirEntry, error) {
-	if path == "." {
-		path = ""
+	return fsys.readDir("readdir", path, nil)
+}
+
+// ReadDirVisible is like ReadDir but omits entries whose names begin with a '.', as is common when
+// listing directories for display. Omitted entries are skipped while listing the directory so
+// their nodes are never loaded. This is a convenience for presentation only; the omitted entries
+// are still present in the FS and can be opened by name.
+func (fsys *FS) ReadDirVisible(path string) ([]fs.DirEntry, error) {
+	return fsys.readDir("readdirvisible", path, func(name string) bool {
+		return !strings.HasPrefix(name, ".")
+	})
+}
+
+// readDir reads the named directory, returning entries for the links accepted by include, or all
+// links if include is nil.
+func (fsys *FS) readDir(op string, path string, include func(name string) bool) ([]fs.DirEntry, error) {
+	node, udir, err := fsys.locateDir(op, path)
+	if err != nil {
+		return nil, err
 	}
 
-	node, _, err := fsys.locateNode(path)
+	links, err := fsys.dirLinks(node, udir)
 	if err != nil {
-		return nil, &fs.PathError{
-			Op:   "readdir",
+		return nil, newPathError(op, path, node.Cid(), err)
+	}
+
+	entries := []fs.DirEntry{}
+	for _, l := range links {
+		if include != nil && !include(l.Name) {
+			continue
+		}
+		entries = append(entries, newDirEntry(fsys.context(), fsys.getter, l))
+	}
+
+	return entries, nil
+}
+
+// ReadDirIter returns an iterator over the entries of the named directory that yields each entry
+// as soon as it is found, so the first entries of a large HAMT sharded directory are available
+// before the rest of the shard has been loaded. Entries are yielded in the order they are stored
+// in the directory rather than sorted by name. Iteration stops when yield returns false. If an
+// error occurs while listing the directory it is yielded with a nil entry and iteration stops.
+// Each entry is a *DirEntry whose node is not loaded until its type or info is requested. The
+// iterator may be used more than once and by several goroutines at the same time, since each
+// iteration over a sharded directory reads the shard independently.
+func (fsys *FS) ReadDirIter(path string) (func(yield func(fs.DirEntry, error) bool), error) {
+	node, udir, err := fsys.locateDir("readdiriter", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(fs.DirEntry, error) bool) {
+		ctx := fsys.context()
+		udir := udir
+		if isShardNode(node) {
+			// A shard caches the nodes it loads without locking so each iteration needs its own
+			var err error
+			udir, err = uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(fsys.getter), node)
+			if err != nil {
+				yield(nil, newPathError("readdiriter", path, node.Cid(), fmt.Errorf("new directory from node: %w", err)))
+				return
+			}
+		}
+		err := forEachLink(ctx, udir, func(l *ipld.Link) bool {
+			return yield(newDirEntry(ctx, fsys.getter, l), nil)
+		})
+		if err != nil {
+			yield(nil, newPathError("readdiriter", path, node.Cid(), err))
+		}
+	}, nil
+}
+
+// locateDir returns the node of the named directory and a uio.Directory for reading it. Errors
+// are reported as an *fs.PathError or *NodeError using the supplied op.
+func (fsys *FS) locateDir(op string, path string) (ipld.Node, uio.Directory, error) {
+	if err := ValidatePath(path); err != nil {
+		return nil, nil, &fs.PathError{
+			Op:   op,
 			Path: path,
 			Err:  err,
 		}
 	}
 
+	lookup := path
+	if lookup == "." {
+		lookup = ""
+	}
+
+	node, _, err := fsys.locateNode(fsys.context(), op, lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := unwrapMetadata(fsys.context(), node, fsys.getter)
+	if err != nil {
+		return nil, nil, newPathError(op, path, node.Cid(), err)
+	}
+	node = wrapped
+
 	udir, err := uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(fsys.getter), node)
 	if err != nil {
 		if errors.Is(err, uio.ErrNotADir) {
-			return nil, &fs.PathError{
-				Op:   "readdir",
-				Path: path,
-				Err:  fs.ErrInvalid,
-			}
+			return nil, nil, newPathError(op, path, node.Cid(), errNotDir(node))
 		}
-		return nil, &fs.PathError{
-			Op:   "readdir",
+		return nil, nil, newPathError(op, path, node.Cid(), fmt.Errorf("new directory from node: %w", err))
+	}
+	return node, udir, nil
+}
+
+// openDir returns a Dir for the named directory, resolving the path using ctx. A path of "." or ""
+// names the root. Errors are reported as an *fs.PathError or *NodeError using the supplied op.
+func (fsys *FS) openDir(ctx context.Context, op string, path string) (*Dir, error) {
+	lookup := path
+	if lookup == "." {
+		lookup = ""
+	}
+	node, _, err := fsys.locateNode(ctx, op, lookup)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := unwrapMetadata(ctx, node, fsys.getter)
+	if err != nil {
+		return nil, newPathError(op, path, node.Cid(), err)
+	}
+	node = wrapped
+
+	d, err := newDir(ctx, "", node, fsys.getter)
+	if err != nil {
+		if errors.Is(err, uio.ErrNotADir) {
+			return nil, newPathError(op, path, node.Cid(), errNotDir(node))
+		}
+		return nil, newPathError(op, path, node.Cid(), err)
+	}
+	return d, nil
+}
+
+// IsDir reports whether the named path is a UnixFS directory, including a HAMT sharded directory.
+// Only the nodes along the path are loaded; no Dir is constructed and no entries are listed.
+func (fsys *FS) IsDir(path string) (bool, error) {
+	if err := ValidatePath(path); err != nil {
+		return false, &fs.PathError{
+			Op:   "isdir",
 			Path: path,
-			Err:  fmt.Errorf("new directory from node: %w", err),
+			Err:  err,
 		}
 	}
 
-	var names []string
-	if err := udir.ForEachLink(fsys.context(), func(l *ipld.Link) error {
-		names = append(names, l.Name)
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("list names: %w", err)
+	if path == "." {
+		path = ""
+	}
+	node, _, err := fsys.locateNode(fsys.context(), "isdir", path)
+	if err != nil {
+		return false, err
 	}
-	sort.Strings(names)
+	wrapped, err := unwrapMetadata(fsys.context(), node, fsys.getter)
+	if err != nil {
+		return false, newPathError("isdir", path, node.Cid(), err)
+	}
+	node = wrapped
+	return isDirNode(node), nil
+}
 
-	entries := []fs.DirEntry{}
-	for _, name := range names {
-		entry, err := dirEntry(fsys.context(), fsys.getter, udir, name)
+// Exists reports whether the named file or directory exists. Only the nodes along the path are
+// loaded; no File or Dir is constructed and none of a file's content is read. A path that is
+// missing or that passes through a file does not exist and is reported as false with a nil error.
+// As with Open, a node that the getter does not have is treated as missing. An error is returned
+// only if the path is invalid or a node along it could not be loaded for some other reason.
+func (fsys *FS) Exists(path string) (bool, error) {
+	if err := ValidatePath(path); err != nil {
+		return false, &fs.PathError{
+			Op:   "exists",
+			Path: path,
+			Err:  err,
+		}
+	}
+
+	if path == "." {
+		path = ""
+	}
+	_, _, err := fsys.locateNode(fsys.context(), "exists", path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ReadLink returns the target of the named symbolic link exactly as it is recorded in the link.
+// Symbolic links earlier in the path are followed but the named link itself is not. If the named
+// path is not a symbolic link the error wraps fs.ErrInvalid.
+//
+// Open and the other methods that resolve paths follow symbolic links, interpreting targets
+// relative to the directory containing the link. Targets that are absolute or that refer outside
+// the root of the FS cannot be followed and result in an error wrapping fs.ErrInvalid.
+func (fsys *FS) ReadLink(path string) (string, error) {
+	if err := ValidatePath(path); err != nil {
+		return "", &fs.PathError{
+			Op:   "readlink",
+			Path: path,
+			Err:  err,
+		}
+	}
+
+	if path == "." {
+		path = ""
+	}
+	node, _, err := fsys.resolvePath(fsys.context(), "readlink", path, false)
+	if err != nil {
+		return "", err
+	}
+
+	target, ok := symlinkTarget(node)
+	if !ok {
+		return "", newPathError("readlink", path, node.Cid(), fmt.Errorf("not a symbolic link: %w", fs.ErrInvalid))
+	}
+	return target, nil
+}
+
+// ETag returns a strong HTTP entity tag for the FS, formed from the CID of its root node. The CID
+// identifies the entire content of the FS, so the tag changes whenever any content changes. The
+// tag is quoted as required for use in ETag and If-None-Match headers.
+func (fsys *FS) ETag() (string, error) {
+	c := fsys.RootCid()
+	if !c.Defined() {
+		return "", fmt.Errorf("root cid is unknown: %w", fs.ErrInvalid)
+	}
+	return `"` + c.String() + `"`, nil
+}
+
+// RootCid returns the CID of the root node of the FS, which identifies the entire tree. For an FS
+// returned by Sub this is the CID of the subdirectory. It returns cid.Undef if the FS has no root.
+func (fsys *FS) RootCid() cid.Cid {
+	if fsys.root == nil {
+		return cid.Undef
+	}
+	return fsys.root.Cid()
+}
+
+// A Link describes an entry in a directory using only the information recorded by the directory.
+type Link struct {
+	Name string   // name of the entry
+	Cid  cid.Cid  // CID of the entry's root node
+	Size uint64   // cumulative size of the entry's DAG as recorded by the directory
+	Type LinkType // type of the entry as far as it can be inferred from the CID
+}
+
+// LinkType is the type of the node a Link points to as far as it can be inferred without loading it.
+type LinkType int
+
+const (
+	// LinkTypeUnknown is used for dag-pb nodes which may be files or directories.
+	LinkTypeUnknown LinkType = iota
+	// LinkTypeFile is used for nodes that can only be files, such as raw leaves.
+	LinkTypeFile
+)
+
+// RootLinks returns the links of the root directory sorted by name. The linked nodes are not
+// loaded, so this is the cheapest way to list the top level of an FS. Listing a sharded root
+// directory requires loading its internal shard nodes.
+func (fsys *FS) RootLinks() ([]Link, error) {
+	if fsys.closed.Load() {
+		return nil, newPathError("rootlinks", ".", fsys.root.Cid(), fs.ErrClosed)
+	}
+
+	udir, err := fsys.rootDir()
+	if err != nil {
+		return nil, newPathError("rootlinks", ".", fsys.root.Cid(), err)
+	}
+	links, err := fsys.dirLinks(fsys.root, udir)
+	if err != nil {
+		return nil, newPathError("rootlinks", ".", fsys.root.Cid(), err)
+	}
+
+	rls := make([]Link, len(links))
+	for i, l := range links {
+		rls[i] = Link{
+			Name: l.Name,
+			Cid:  l.Cid,
+			Size: l.Size,
+			Type: LinkTypeUnknown,
+		}
+		if l.Cid.Type() != cid.DagProtobuf {
+			rls[i].Type = LinkTypeFile
+		}
+	}
+	return rls, nil
+}
+
+// Capabilities describes the UnixFS features used by an FS.
+type Capabilities struct {
+	HAMTSharding bool // directories are HAMT sharded
+	Symlinks     bool // symbolic links are present
+	RawLeaves    bool // file content is stored in raw leaf blocks
+	Mode         bool // permission bits other than the UnixFS defaults are recorded
+	ModTime      bool // modification times are recorded
+}
+
+// Capabilities reports the UnixFS features used by the FS. It is a best-effort probe of the root
+// node: raw leaves are detected from the CIDs of the root's links, and at most capabilitySampleLinks
+// of the nodes the root links to are loaded to look for symbolic links, modes and modification
+// times, so a feature that is used only elsewhere in the tree is not reported. The links of a
+// sharded root are internal shard nodes so none are loaded. Metadata nodes are looked through to
+// the node they wrap and nodes that cannot be loaded are skipped. A mode is only detected when it
+// differs from the UnixFS default, so a mode that is recorded with the default value is not
+// reported.
+func (fsys *FS) Capabilities() Capabilities {
+	var caps Capabilities
+	if fsys.root == nil || fsys.closed.Load() {
+		return caps
+	}
+
+	sampleNode(fsys.root, &caps)
+	links := fsys.root.Links()
+	for _, l := range links {
+		if l.Cid.Type() == cid.Raw {
+			caps.RawLeaves = true
+			break
+		}
+	}
+	if caps.HAMTSharding {
+		return caps
+	}
+
+	loaded := 0
+	for _, l := range links {
+		if loaded == capabilitySampleLinks {
+			break
+		}
+		if l.Cid.Type() == cid.Raw {
+			continue
+		}
+		loaded++
+		child, err := l.GetNode(fsys.context(), fsys.getter)
+		if err != nil {
+			continue
+		}
+		child, err = unwrapMetadata(fsys.context(), child, fsys.getter)
 		if err != nil {
-			return entries, &fs.PathError{
-				Op:   "readdir",
-				Path: name,
-				Err:  err,
+			continue
+		}
+		if child.Cid().Type() == cid.Raw {
+			caps.RawLeaves = true
+			continue
+		}
+		sampleNode(child, &caps)
+	}
+	return caps
+}
+
+// sampleNode records the UnixFS features used by a single node in caps. Metadata nodes record no
+// mode of their own so they are ignored.
+func sampleNode(node ipld.Node, caps *Capabilities) {
+	pn, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return
+	}
+
+	switch fsn.Type() {
+	case unixfs.THAMTShard:
+		caps.HAMTSharding = true
+	case unixfs.TMetadata:
+		return
+	case unixfs.TSymlink:
+		caps.Symlinks = true
+	case unixfs.TFile:
+		for _, l := range pn.Links() {
+			if l.Cid.Type() == cid.Raw {
+				caps.RawLeaves = true
+				break
 			}
 		}
-		entries = append(entries, entry)
+	}
+	if unixfsMode(fsn) != defaultPerm(fsn) {
+		caps.Mode = true
+	}
+	if !fsn.ModTime().IsZero() {
+		caps.ModTime = true
+	}
+}
+
+// defaultPerm returns the permissions UnixFS uses for the node when it does not record a mode.
+func defaultPerm(fsn *unixfs.FSNode) fs.FileMode {
+	if fsn.IsDir() {
+		return defaultDirPerm
+	}
+	return defaultFilePerm
+}
+
+// ValidatePath reports whether the path is valid for use with Open, Sub and ReadDir, following the
+// rules of fs.ValidPath. A path that is not valid results in an error wrapping fs.ErrInvalid that
+// describes why the path was rejected.
+func ValidatePath(path string) error {
+	if path == "." {
+		return nil
+	}
+	if path == "" {
+		return fmt.Errorf("empty path: %w", fs.ErrInvalid)
+	}
+	if !utf8.ValidString(path) {
+		return fmt.Errorf("not valid utf-8: %w", fs.ErrInvalid)
+	}
+	if strings.HasPrefix(path, "/") {
+		return fmt.Errorf("leading slash: %w", fs.ErrInvalid)
+	}
+	if strings.HasSuffix(path, "/") {
+		return fmt.Errorf("trailing slash: %w", fs.ErrInvalid)
+	}
+	for _, elem := range strings.Split(path, "/") {
+		switch elem {
+		case "":
+			return fmt.Errorf("empty element: %w", fs.ErrInvalid)
+		case ".":
+			return fmt.Errorf("contains . element: %w", fs.ErrInvalid)
+		case "..":
+			return fmt.Errorf("contains .. element: %w", fs.ErrInvalid)
+		}
+	}
+	return nil
+}
 
+// dirLinks returns the links of the directory sorted by name. The links of sharded directories
+// are cached by cid since listing them requires walking every internal node of the shard.
+func (fsys *FS) dirLinks(node ipld.Node, udir uio.Directory) ([]*ipld.Link, error) {
+	cache := fsys.links.Load()
+	if !isShardNode(node) || cache == nil {
+		return sortedLinks(fsys.context(), udir)
 	}
 
-	return entries, nil
+	if v, ok := cache.Get(node.Cid()); ok {
+		return v.([]*ipld.Link), nil
+	}
+
+	links, err := sortedLinks(fsys.context(), udir)
+	if err != nil {
+		return nil, err
+	}
+	cache.Add(node.Cid(), links)
+	return links, nil
+}
+
+// rootDir returns the root directory of the FS. A sharded root is read afresh for each use since
+// looking up entries in a shard caches the nodes it loads without any locking.
+func (fsys *FS) rootDir() (uio.Directory, error) {
+	if fsys.udir != nil {
+		return fsys.udir, nil
+	}
+	udir, err := uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(fsys.getter), fsys.root)
+	if err != nil {
+		return nil, fmt.Errorf("new directory from node: %w", err)
+	}
+	return udir, nil
+}
+
+// locateNode resolves the path to a node, following any symbolic links, returning the node and its
+// name. Errors are reported as an *fs.PathError or *NodeError using the supplied op.
+func (fsys *FS) locateNode(ctx context.Context, op string, path string) (ipld.Node, string, error) {
+	return fsys.resolvePath(ctx, op, path, true)
 }
 
-func (fsys *FS) locateNode(path string) (ipld.Node, string, error) {
+// resolvePath returns the node at the path and the name it was found under. Symbolic links within
+// the path are followed, as is a symbolic link at the end of the path if follow is true. The name
+// returned is always that of the last element of the path, even when it is a followed link.
+func (fsys *FS) resolvePath(ctx context.Context, op string, path string, follow bool) (ipld.Node, string, error) {
+	fullpath := path
+	if fsys.closed.Load() {
+		return nil, "", newPathError(op, fullpath, cid.Undef, fs.ErrClosed)
+	}
+
 	path = strings.Trim(path, "/")
 	parts := ipath.SplitList(path)
 	if len(parts) == 1 && parts[0] == "" {
-		node, err := fsys.udir.GetNode()
-		if err != nil {
-			return nil, "", fmt.Errorf("get root node: %w", err)
-		}
-		return node, "", nil
+		return fsys.root, "", nil
+	}
+
+	maxDepth := fsys.opts.maxPathDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxPathDepth
+	}
+	if len(parts) > maxDepth {
+		return nil, "", newPathError(op, fullpath, cid.Undef, fmt.Errorf("path has %d elements, more than the limit of %d: %w", len(parts), maxDepth, fs.ErrInvalid))
+	}
+
+	maxHops := fsys.opts.maxSymlinkHops
+	if maxHops == 0 {
+		maxHops = DefaultMaxSymlinkHops
 	}
 
-	var cur uio.Directory
-	cur = fsys.udir
-	for i, segment := range parts {
-		childNode, err := cur.Find(fsys.context(), segment)
+	type level struct {
+		node ipld.Node
+		dir  uio.Directory
+	}
+	// levels holds the directories from the root to the one currently being searched so that
+	// symbolic link targets may refer to parent directories.
+	rootDir, err := fsys.rootDir()
+	if err != nil {
+		return nil, "", newPathError(op, fullpath, fsys.root.Cid(), err)
+	}
+	levels := []level{{node: fsys.root, dir: rootDir}}
+
+	// parts holds the elements still to be resolved. The targets of symbolic links are placed in
+	// front of the remaining elements of the original path, which are always the last remaining
+	// elements.
+	remaining := len(parts)
+	var name string
+	hops := 0
+	for len(parts) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, "", newPathError(op, fullpath, levels[len(levels)-1].node.Cid(), err)
+		}
+
+		segment := parts[0]
+		original := len(parts) == remaining
+		parts = parts[1:]
+		if original {
+			remaining--
+		}
+
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			if len(levels) == 1 {
+				return nil, "", newPathError(op, fullpath, fsys.root.Cid(), fmt.Errorf("symbolic link target is outside the filesystem: %w", fs.ErrInvalid))
+			}
+			levels = levels[:len(levels)-1]
+			continue
+		}
+
+		cur := levels[len(levels)-1]
+		childNode, childName, err := fsys.findChild(ctx, cur.dir, segment)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) || errors.Is(err, ipld.ErrNotFound{}) {
-				return nil, "", fs.ErrNotExist
+				return nil, "", newPathError(op, fullpath, missingCid(err, cur.node.Cid()), fs.ErrNotExist)
 			}
-			return nil, "", fmt.Errorf("find: %w", err)
+			return nil, "", newPathError(op, fullpath, cur.node.Cid(), fmt.Errorf("find: %w", err))
+		}
+		if original && remaining == 0 {
+			name = childName
+		}
+
+		if target, ok := symlinkTarget(childNode); ok && (len(parts) > 0 || follow) {
+			hops++
+			if hops > maxHops {
+				return nil, "", newPathError(op, fullpath, childNode.Cid(), fmt.Errorf("too many levels of symbolic links: %w", fs.ErrInvalid))
+			}
+			if target == "" {
+				return nil, "", newPathError(op, fullpath, childNode.Cid(), fmt.Errorf("symbolic link target is empty: %w", fs.ErrNotExist))
+			}
+			if strings.HasPrefix(target, "/") {
+				return nil, "", newPathError(op, fullpath, childNode.Cid(), fmt.Errorf("symbolic link target %q is absolute: %w", target, fs.ErrInvalid))
+			}
+			parts = append(strings.Split(target, "/"), parts...)
+			continue
 		}
 
-		if i == len(parts)-1 {
-			// Last segment of path
-			return childNode, segment, nil
+		if len(parts) == 0 {
+			return childNode, name, nil
 		}
 
+		wrapped, err := unwrapMetadata(ctx, childNode, fsys.getter)
+		if err != nil {
+			return nil, "", newPathError(op, fullpath, childNode.Cid(), err)
+		}
+		childNode = wrapped
 		childDir, err := uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(fsys.getter), childNode)
 		if err != nil {
 			if errors.Is(err, uio.ErrNotADir) {
-				return nil, "", fs.ErrInvalid
+				return nil, "", newPathError(op, fullpath, childNode.Cid(), errNotDir(childNode))
 			}
-			return nil, "", fmt.Errorf("new directory from node: %w", err)
+			return nil, "", newPathError(op, fullpath, childNode.Cid(), fmt.Errorf("new directory from node: %w", err))
 		}
-
-		cur = childDir
+		levels = append(levels, level{node: childNode, dir: childDir})
 	}
-	return nil, "", fs.ErrInvalid
+
+	// The path ended with a symbolic link whose target resolved to a directory already visited
+	return levels[len(levels)-1].node, name, nil
 }
 
-func dirEntry(ctx context.Context, getter ipld.NodeGetter, dir uio.Directory, name string) (fs.DirEntry, error) {
+// findChild returns the child of the directory with the supplied name, and the name it was found
+// under, unescaping the name if the FS matches URL escaped names and there is no literal match.
+func (fsys *FS) findChild(ctx context.Context, dir uio.Directory, name string) (ipld.Node, string, error) {
 	node, err := dir.Find(ctx, name)
+	if err == nil || !fsys.opts.urlUnescape || !strings.Contains(name, "%") {
+		return node, name, err
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, name, err
+	}
+
+	unescaped, uerr := url.PathUnescape(name)
+	if uerr != nil || unescaped == name {
+		return nil, name, err
+	}
+	node, err = dir.Find(ctx, unescaped)
+	return node, unescaped, err
+}
+
+// linkEntry returns a File, Dir or Symlink for the node the link points to.
+func linkEntry(ctx context.Context, getter ipld.NodeGetter, l *ipld.Link) (entry, error) {
+	node, err := l.GetNode(ctx, getter)
 	if err != nil {
-		return nil, fmt.Errorf("find: %w", err)
+		return nil, fmt.Errorf("get node: %w", err)
 	}
 
+	return newEntry(ctx, l.Name, node, getter)
+}
+
+// entry is implemented by the File, Dir and Symlink types so they may be returned from both Open and ReadDir.
+type entry interface {
+	fs.File
+	fs.DirEntry
+	fileInfo() *FileInfo
+}
+
+// newEntry returns a File, Dir or Symlink representing the UnixFS node.
+func newEntry(ctx context.Context, name string, node ipld.Node, getter ipld.NodeGetter) (entry, error) {
 	switch tnode := node.(type) {
 	case *merkledag.ProtoNode:
 		fsn, err := unixfs.FSNodeFromBytes(tnode.Data())
 		if err != nil {
-			return nil, err
+			// A dag-pb node that does not hold UnixFS data
+			return newBlockFile(ctx, name, node), nil
 		}
 
 		switch fsn.Type() {
 		case unixfs.TDirectory, unixfs.THAMTShard:
 			return newDir(ctx, name, node, getter)
 
-		case unixfs.TFile:
+		case unixfs.TFile, unixfs.TRaw:
+			// A raw UnixFS node holds file data directly
 			return newFile(ctx, name, node, getter)
 
-		case unixfs.TRaw:
+		case unixfs.TMetadata:
+			return newMetadataEntry(ctx, name, tnode, getter)
+
 		case unixfs.TSymlink:
-		default:
-			return nil, fs.ErrInvalid
+			return newSymlink(name, node, fsn), nil
+
 		}
+
+		return nil, fmt.Errorf("unsupported UnixFS node type %s: %w", fsn.Type(), fs.ErrInvalid)
+
+	case *merkledag.RawNode:
+		// A raw leaf, as produced when importing with raw leaves, holds the data of a single block file
+		return newFile(ctx, name, node, getter)
+
+	default:
+		// Some other kind of IPLD node, such as a dag-cbor document, linked from a UnixFS directory
+		return newBlockFile(ctx, name, node), nil
+	}
+}
+
+// unwrapMetadata returns the node wrapped by a UnixFS metadata node, or the node itself if it is
+// not a metadata node. Any directory or file may be wrapped to record its mime type, so a node must
+// be unwrapped before it is read as a directory.
+func unwrapMetadata(ctx context.Context, node ipld.Node, getter ipld.NodeGetter) (ipld.Node, error) {
+	for isMetadataNode(node) {
+		child, err := metadataChild(ctx, node, getter)
+		if err != nil {
+			return nil, err
+		}
+		node = child
 	}
+	return node, nil
+}
 
-	return nil, fs.ErrInvalid
+// metadataChild loads the node wrapped by the metadata node.
+func metadataChild(ctx context.Context, node ipld.Node, getter ipld.NodeGetter) (ipld.Node, error) {
+	if len(node.Links()) == 0 {
+		return nil, fmt.Errorf("metadata node has no links: %w", fs.ErrInvalid)
+	}
+	child, err := node.Links()[0].GetNode(ctx, getter)
+	if err != nil {
+		return nil, fmt.Errorf("get wrapped node: %w", err)
+	}
+	return child, nil
+}
+
+// newMetadataEntry returns an entry for the node wrapped by a UnixFS metadata node.
+// The entry takes the type of the wrapped node and reports the mime type held by the metadata.
+func newMetadataEntry(ctx context.Context, name string, node *merkledag.ProtoNode, getter ipld.NodeGetter) (entry, error) {
+	md, err := unixfs.MetadataFromBytes(node.Data())
+	if err != nil {
+		return nil, fmt.Errorf("metadata from bytes: %w", err)
+	}
+
+	child, err := metadataChild(ctx, node, getter)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := newEntry(ctx, name, child, getter)
+	if err != nil {
+		return nil, err
+	}
+	e.fileInfo().mimeType = md.MimeType
+	return e, nil
 }
@@ -1,24 +1,46 @@
 package mfsng
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/ipld/merkledag"
 	mdtest "github.com/ipfs/boxo/ipld/merkledag/test"
 	ufs "github.com/ipfs/boxo/ipld/unixfs"
 	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	utest "github.com/ipfs/boxo/ipld/unixfs/test"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mh "github.com/multiformats/go-multihash"
 )
 
 func TestFS(t *testing.T) {
@@ -140,9 +162,9 @@ func TestFSReadDir(t *testing.T) {
 	}
 
 	want := []fs.FileInfo{
-		&FileInfo{name: "goodbye.txt", size: 7},
-		&FileInfo{name: "hello2.txt", size: 6},
-		&FileInfo{name: "sub", size: 228, filemode: fs.ModeDir},
+		&FileInfo{name: "goodbye.txt", size: 7, filemode: 0o644},
+		&FileInfo{name: "hello2.txt", size: 6, filemode: 0o644},
+		&FileInfo{name: "sub", size: 228, filemode: fs.ModeDir | 0o755},
 	}
 
 	fileInfoComparer := cmp.Comparer(func(a, b *FileInfo) bool {
@@ -155,101 +177,4576 @@ func TestFSReadDir(t *testing.T) {
 	if diff := cmp.Diff(want, got, ignoreSliceOrder, fileInfoComparer); diff != "" {
 		t.Errorf("Glob() mismatch (-want +got):\n%s", diff)
 	}
+
+	// The size of a directory is its serialized length plus the sizes recorded in its links
+	for _, fi := range got {
+		if !fi.IsDir() {
+			continue
+		}
+		node := fi.Sys().(ipld.Node)
+		wantSize := int64(len(node.RawData()))
+		for _, l := range node.Links() {
+			wantSize += int64(l.Size)
+		}
+		if fi.Size() != wantSize {
+			t.Errorf("%s: got size %d, wanted cumulative size %d", fi.Name(), fi.Size(), wantSize)
+		}
+	}
+}
+
+func TestReadDirMatchesDirReadDir(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/file1":     []byte("file1"),
+		"a/file2.txt": []byte("file2 content"),
+		"a/large.bin": bytes.Repeat([]byte("0123456789"), 200),
+		"a/b/file3":   []byte("file3"),
+		"a/c/empty":   nil, // empty dir
+	})
+
+	type summary struct {
+		Name    string
+		Size    int64
+		Mode    fs.FileMode
+		ModTime time.Time
+		Cid     string
+	}
+	summarize := func(entries []fs.DirEntry) []summary {
+		var sums []summary
+		for _, e := range entries {
+			fi, err := e.Info()
+			if err != nil {
+				t.Fatalf("failed to get info for %s: %v", e.Name(), err)
+			}
+			sums = append(sums, summary{
+				Name:    fi.Name(),
+				Size:    fi.Size(),
+				Mode:    fi.Mode(),
+				ModTime: fi.ModTime(),
+				Cid:     fi.(*FileInfo).Cid().String(),
+			})
+		}
+		sort.Slice(sums, func(i, j int) bool { return sums[i].Name < sums[j].Name })
+		return sums
+	}
+
+	fsEntries, err := fsys.ReadDir("a")
+	if err != nil {
+		t.Fatalf("failed to read dir using fs: %v", err)
+	}
+
+	f, err := fsys.Open("a")
+	if err != nil {
+		t.Fatalf("failed to open dir: %v", err)
+	}
+	defer f.Close()
+	dirEntries, err := f.(*Dir).ReadDir(-1)
+	if err != nil {
+		t.Fatalf("failed to read dir using dir: %v", err)
+	}
+
+	if diff := cmp.Diff(summarize(fsEntries), summarize(dirEntries)); diff != "" {
+		t.Errorf("ReadDir mismatch (-fs +dir):\n%s", diff)
+	}
+}
+
+func TestOpenChecksForValidName(t *testing.T) {
+	testCases := []struct {
+		name  string
+		valid bool
+	}{
+		{".", true},
+		{"x", true},
+		{"x/y", true},
+
+		{"", false},
+		{"..", false},
+		{"/", false},
+		{"x/", false},
+		{"/x", false},
+		{"x/y/", false},
+		{"/x/y", false},
+		{"./", false},
+		{"./x", false},
+		{"x/.", false},
+		{"x/./y", false},
+		{"../", false},
+		{"../x", false},
+		{"x/..", false},
+		{"x/../y", false},
+		{"x//y", false},
+		{`x\`, true},
+		{`x\y`, true},
+		{`x:y`, true},
+		{`\x`, true},
+	}
+
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, nil)
+
+	for _, tc := range testCases {
+		_, err := fsys.Open(tc.name)
+
+		if errors.Is(err, fs.ErrInvalid) {
+			if tc.valid {
+				t.Errorf("%q invalid, expected name to be valid: %v", tc.name, err)
+			}
+		} else {
+			if !tc.valid {
+				t.Errorf("%q valid, expected name to be invalid: %v", tc.name, err)
+			}
+		}
+	}
+}
+
+func TestReadDirIter(t *testing.T) {
+	names := make([]string, 2000)
+	for i := range names {
+		names[i] = fmt.Sprintf("file%04d", i)
+	}
+	sharded := shardedFS(t, names)
+	cg := &countingGetter{NodeGetter: inMemoryGetter(t, sharded)}
+	fsys := mustReadFS(t, sharded.root, cg)
+
+	iter, err := fsys.ReadDirIter(".")
+	if err != nil {
+		t.Fatalf("ReadDirIter: %v", err)
+	}
+
+	var got []string
+	iter(func(de fs.DirEntry, err error) bool {
+		if err != nil {
+			t.Fatalf("iteration error: %v", err)
+		}
+		got = append(got, de.Name())
+		return true
+	})
+	if diff := cmp.Diff(names, got, ignoreSliceOrder); diff != "" {
+		t.Errorf("entries mismatch (-want +got):\n%s", diff)
+	}
+	full := cg.count()
+
+	before := cg.count()
+	var first []string
+	iter(func(de fs.DirEntry, err error) bool {
+		if err != nil {
+			t.Fatalf("iteration error: %v", err)
+		}
+		first = append(first, de.Name())
+		return len(first) < 5
+	})
+	if len(first) != 5 {
+		t.Errorf("got %d entries, wanted iteration to stop after 5", len(first))
+	}
+	if partial := cg.count() - before; partial >= full {
+		t.Errorf("got %d node requests for 5 entries, wanted fewer than the %d for a full listing", partial, full)
+	}
+
+	// The same iterator may be used by several goroutines at once
+	var wg sync.WaitGroup
+	counts := make([]int, 4)
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			iter(func(de fs.DirEntry, err error) bool {
+				if err != nil {
+					t.Errorf("concurrent iteration error: %v", err)
+					return false
+				}
+				counts[i]++
+				return true
+			})
+		}(i)
+	}
+	wg.Wait()
+	for i, n := range counts {
+		if n != len(names) {
+			t.Errorf("concurrent iteration %d: got %d entries, wanted %d", i, n, len(names))
+		}
+	}
+
+	if _, err := sharded.ReadDirIter(names[0]); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v for a file, wanted one wrapping %v", err, fs.ErrInvalid)
+	}
+	if _, err := sharded.ReadDirIter("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v for a missing path, wanted one wrapping %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"hello.txt":            []byte("hello1"),
+		"test/hello2.txt":      []byte("hello2"),
+		"test/sub/hello4.txt":  []byte("hello4"),
+		"test/sub2/hello5.txt": []byte("hello5"),
+		"test/goodbye.txt":     []byte("goodbye"),
+	})
+
+	got, err := fs.Glob(fsys, "*/*.txt")
+	if err != nil {
+		t.Fatalf("failed to glob: %v", err)
+	}
+
+	want := []string{
+		"test/hello2.txt",
+		"test/goodbye.txt",
+	}
+
+	if diff := cmp.Diff(want, got, ignoreSliceOrder); diff != "" {
+		t.Errorf("Glob() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGlobMatchesFSGlob(t *testing.T) {
+	files := map[string][]byte{
+		"hello.txt":            []byte("hello1"),
+		"test/hello2.txt":      []byte("hello2"),
+		"test/sub/hello4.txt":  []byte("hello4"),
+		"test/sub2/hello5.txt": []byte("hello5"),
+		"test/goodbye.txt":     []byte("goodbye"),
+		"other/hello6.txt":     []byte("hello6"),
+	}
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, files)
+
+	mfs := fstest.MapFS{}
+	for name, content := range files {
+		mfs[name] = &fstest.MapFile{Data: content}
+	}
+
+	patterns := []string{
+		"*",
+		"*.txt",
+		"*/*.txt",
+		"*/*/*.txt",
+		"test/*",
+		"test/sub*/hello?.txt",
+		"t[e]st/[gh]*",
+		"hello.txt",
+		"test/sub/hello4.txt",
+		"missing/*",
+		"hello.txt/*",
+		".",
+		"*/",
+	}
+	for _, pattern := range patterns {
+		t.Run(pattern, func(t *testing.T) {
+			want, err := fs.Glob(mfs, pattern)
+			if err != nil {
+				t.Fatalf("fs.Glob: %v", err)
+			}
+			got, err := fsys.Glob(pattern)
+			if err != nil {
+				t.Fatalf("Glob: %v", err)
+			}
+			if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Glob() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("bad pattern", func(t *testing.T) {
+		_, err := fsys.Glob("test/[")
+		if !errors.Is(err, path.ErrBadPattern) {
+			t.Errorf("got error %v, wanted %v", err, path.ErrBadPattern)
+		}
+	})
+
+	t.Run("leaves not loaded", func(t *testing.T) {
+		cg := &countingGetter{NodeGetter: ds}
+		cfs, err := ReadFS(fsys.root, cg)
+		if err != nil {
+			t.Fatalf("ReadFS: %v", err)
+		}
+		if _, err := cfs.Glob("*/*.txt"); err != nil {
+			t.Fatalf("Glob: %v", err)
+		}
+		// the children of the root are loaded to find directories but none of the matched files are
+		if got := cg.count(); got != 3 {
+			t.Errorf("got %d node requests, wanted 3", got)
+		}
+	})
+}
+
+func TestReadDirMetadataWrappedFile(t *testing.T) {
+	ds := mdtest.Mock()
+	content := []byte("wrapped content")
+	filenode := utest.GetNode(t, ds, content, utest.UseProtoBufLeaves)
+
+	mdata, err := ufs.BytesForMetadata(&ufs.Metadata{MimeType: "text/plain"})
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	mdnode := merkledag.NodeWithData(mdata)
+	if err := mdnode.AddNodeLink("file", filenode); err != nil {
+		t.Fatalf("failed to link wrapped file: %v", err)
+	}
+
+	fsys := buildFSWithNodes(t, ds, map[string][]byte{
+		"plain.txt": []byte("plain"),
+	}, map[string]ipld.Node{
+		"wrapped.txt": mdnode,
+	})
+
+	entries, err := fsys.ReadDir(".")
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, wanted 2", len(entries))
+	}
+
+	var found bool
+	for _, e := range entries {
+		if e.Name() != "wrapped.txt" {
+			continue
+		}
+		found = true
+		if e.IsDir() {
+			t.Errorf("got IsDir=true, wanted false")
+		}
+		info, err := e.Info()
+		if err != nil {
+			t.Fatalf("failed to get entry info: %v", err)
+		}
+		if info.Size() != int64(len(content)) {
+			t.Errorf("got size %d, wanted %d", info.Size(), len(content))
+		}
+		if mt := info.(*FileInfo).MimeType(); mt != "text/plain" {
+			t.Errorf("got mime type %q, wanted %q", mt, "text/plain")
+		}
+	}
+	if !found {
+		t.Fatalf("wrapped.txt not found in directory listing")
+	}
+
+	data, err := fs.ReadFile(fsys, "wrapped.txt")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got data %q, wanted %q", data, content)
+	}
+}
+
+func TestMetadataWrappedDir(t *testing.T) {
+	ds := mdtest.Mock()
+	wrappedNode := buildRootNode(t, ds, map[string][]byte{
+		"f": []byte("wrapped content"),
+	}, nil)
+
+	mdata, err := ufs.BytesForMetadata(&ufs.Metadata{MimeType: "inode/directory"})
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	mdnode := merkledag.NodeWithData(mdata)
+	if err := mdnode.AddNodeLink("dir", wrappedNode); err != nil {
+		t.Fatalf("failed to link wrapped directory: %v", err)
+	}
+
+	fsys := buildFSWithNodes(t, ds, map[string][]byte{
+		"plain.txt": []byte("plain"),
+	}, map[string]ipld.Node{
+		"w": mdnode,
+	})
+
+	if err := fstest.TestFS(fsys, "plain.txt", "w/f"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fsys.ReadDir("w")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "f" {
+		t.Errorf("got entries %v, wanted [f]", entries)
+	}
+
+	sub, err := fsys.Sub("w")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	if err := fstest.TestFS(sub, "f"); err != nil {
+		t.Fatal(err)
+	}
+
+	if isDir, err := fsys.IsDir("w"); err != nil || !isDir {
+		t.Errorf("IsDir: got %v, %v, wanted true with no error", isDir, err)
+	}
+
+	files, dirs, err := fsys.Count(".")
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if files != 2 || dirs != 1 {
+		t.Errorf("Count: got %d files and %d dirs, wanted 2 files and 1 dir", files, dirs)
+	}
+
+	matches, err := fsys.Glob("w/*")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if diff := cmp.Diff([]string{"w/f"}, matches); diff != "" {
+		t.Errorf("Glob mismatch (-want +got):\n%s", diff)
+	}
+
+	manifest, err := fsys.Manifest("w")
+	if err != nil {
+		t.Fatalf("Manifest: %v", err)
+	}
+	if len(manifest) != 1 || manifest[0].Path != "w/f" {
+		t.Errorf("Manifest: got %v, wanted a single entry for w/f", manifest)
+	}
+}
+
+func TestOpenErrorCid(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/b/file": []byte("file content"),
+	})
+
+	sub, err := fsys.Open("a/b")
+	if err != nil {
+		t.Fatalf("failed to open directory: %v", err)
+	}
+	subCid := sub.(*Dir).info.Cid()
+
+	// Remove the block for a/b so resolution fails when it is loaded
+	if err := ds.Remove(context.Background(), subCid); err != nil {
+		t.Fatalf("failed to remove block: %v", err)
+	}
+
+	_, err = fsys.Open("a/b/file")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %q error, wanted %q", err, fs.ErrNotExist)
+	}
+
+	var nerr *NodeError
+	if !errors.As(err, &nerr) {
+		t.Fatalf("got %T error, wanted *NodeError", err)
+	}
+	if nerr.Cid != subCid {
+		t.Errorf("got error cid %s, wanted %s", nerr.Cid, subCid)
+	}
+
+	var perr *fs.PathError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got %T error, wanted to unwrap to *fs.PathError", err)
+	}
+	if perr.Op != "open" || perr.Path != "a/b/file" {
+		t.Errorf("got op %q path %q, wanted op %q path %q", perr.Op, perr.Path, "open", "a/b/file")
+	}
+}
+
+func TestReadFSMulti(t *testing.T) {
+	full := mdtest.Mock()
+	dirnode := buildRootNode(t, full, map[string][]byte{
+		"a/file": []byte("file content"),
+	}, nil)
+
+	// partial only holds the root block
+	partial := mdtest.Mock()
+	if err := partial.Add(context.Background(), dirnode); err != nil {
+		t.Fatalf("failed to add root node: %v", err)
+	}
+
+	fsys, err := ReadFSMulti(dirnode.Cid(), partial, full)
+	if err != nil {
+		t.Fatalf("failed to create fs: %v", err)
+	}
+
+	data, err := fs.ReadFile(fsys, "a/file")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "file content" {
+		t.Errorf("got data %q, wanted %q", data, "file content")
+	}
+
+	fsys, err = ReadFSMulti(dirnode.Cid(), partial)
+	if err != nil {
+		t.Fatalf("failed to create fs: %v", err)
+	}
+
+	_, err = fsys.Open("a/file")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %q error, wanted %q", err, fs.ErrNotExist)
+	}
+}
+
+func TestCount(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"hello.txt":       []byte("hello"),
+		"a/b/file1":       []byte("file1"),
+		"a/b/file2":       []byte("file2"),
+		"a/c":             nil, // empty dir
+		"shared1/x/file3": []byte("file3"),
+		"shared2/x/file3": []byte("file3"), // same content as shared1 so has the same cid
+	})
+
+	testCases := []struct {
+		root  string
+		files int
+		dirs  int
+	}{
+		{root: ".", files: 5, dirs: 7},
+		{root: "a", files: 2, dirs: 2},
+		{root: "a/c", files: 0, dirs: 0},
+		{root: "shared2", files: 1, dirs: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.root, func(t *testing.T) {
+			files, dirs, err := fsys.Count(tc.root)
+			if err != nil {
+				t.Fatalf("failed to count: %v", err)
+			}
+			if files != tc.files {
+				t.Errorf("got %d files, wanted %d", files, tc.files)
+			}
+			if dirs != tc.dirs {
+				t.Errorf("got %d dirs, wanted %d", dirs, tc.dirs)
+			}
+		})
+	}
+
+	// Symbolic links are neither counted nor followed
+	links := symlinkFS(t, map[string]string{"l1": "a", "l2": "b"})
+	files, dirs, err := links.Count(".")
+	if err != nil {
+		t.Fatalf("failed to count links: %v", err)
+	}
+	if files != 2 || dirs != 1 {
+		t.Errorf("got %d files and %d dirs with links, wanted 2 files and 1 dir", files, dirs)
+	}
+
+	_, _, err = fsys.Count("hello.txt")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+
+	_, _, err = fsys.Count("../a")
+	var pe *fs.PathError
+	if !errors.As(err, &pe) || pe.Op != "count" || !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error for invalid path, wanted count path error wrapping %q", err, fs.ErrInvalid)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = fsys.WithContext(ctx).(*FS).Count(".")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %q error, wanted %q", err, context.Canceled)
+	}
+}
+
+func TestOpenFileOptions(t *testing.T) {
+	content := make([]byte, 2000) // spans several blocks
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/file": content,
+	})
+
+	testCases := []struct {
+		name string
+		opts []OpenOption
+		want []byte
+	}{
+		{name: "default", want: content},
+		{name: "offset", opts: []OpenOption{WithOffset(700)}, want: content[700:]},
+		{name: "readahead", opts: []OpenOption{WithReadahead(64)}, want: content},
+		{name: "readahead_offset", opts: []OpenOption{WithReadahead(1024), WithOffset(1500)}, want: content[1500:]},
+		{name: "context", opts: []OpenOption{WithReadContext(context.Background())}, want: content},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fsys.OpenFile("a/file", tc.opts...)
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			defer f.Close()
+
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			if !bytes.Equal(data, tc.want) {
+				t.Errorf("got %d bytes, wanted %d bytes", len(data), len(tc.want))
+			}
+		})
+	}
+
+	t.Run("readahead_seek", func(t *testing.T) {
+		f, err := fsys.OpenFile("a/file", WithReadahead(1024))
+		if err != nil {
+			t.Fatalf("failed to open file: %v", err)
+		}
+		defer f.Close()
+
+		buf := make([]byte, 10)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		pos, err := f.(io.Seeker).Seek(5, io.SeekCurrent)
+		if err != nil {
+			t.Fatalf("failed to seek: %v", err)
+		}
+		if pos != 15 {
+			t.Errorf("got position %d, wanted 15", pos)
+		}
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if !bytes.Equal(buf, content[15:25]) {
+			t.Errorf("got data %v, wanted %v", buf, content[15:25])
+		}
+	})
+
+	_, err := fsys.OpenFile("a", WithOffset(10))
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := fsys.OpenFile("a/file", WithReadContext(ctx))
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	cancel()
+	_, err = io.ReadAll(f)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %q error, wanted %q", err, context.Canceled)
+	}
+
+	// the read context is also used to resolve the path
+	if _, err := fsys.OpenFile("a/file", WithReadContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %q error, wanted %q", err, context.Canceled)
+	}
+}
+
+func TestOpenNonUnixFSChild(t *testing.T) {
+	ds := mdtest.Mock()
+	cbornode, err := cbor.WrapObject(map[string]string{"hello": "world"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatalf("failed to create cbor node: %v", err)
+	}
+	pbnode := merkledag.NodeWithData([]byte("not unixfs"))
+
+	fsys := buildFSWithNodes(t, ds, map[string][]byte{
+		"plain.txt": []byte("plain"),
+	}, map[string]ipld.Node{
+		"doc.cbor": cbornode,
+		"doc.pb":   pbnode,
+	})
+
+	entries, err := fsys.ReadDir(".")
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatalf("failed to get entry info: %v", err)
+		}
+		got[e.Name()] = info.(*FileInfo).IsUnixFS()
+	}
+
+	want := map[string]bool{"plain.txt": true, "doc.cbor": false, "doc.pb": false}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("IsUnixFS() mismatch (-want +got):\n%s", diff)
+	}
+
+	data, err := fs.ReadFile(fsys, "doc.cbor")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, cbornode.RawData()) {
+		t.Errorf("got data %x, wanted raw block data %x", data, cbornode.RawData())
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	testCases := []struct {
+		path string
+		want string // expected error message, empty if valid
+	}{
+		{".", ""},
+		{"x/y", ""},
+		{"", "empty path: invalid argument"},
+		{"/x", "leading slash: invalid argument"},
+		{"x/", "trailing slash: invalid argument"},
+		{"x//y", "empty element: invalid argument"},
+		{"x/./y", "contains . element: invalid argument"},
+		{"../x", "contains .. element: invalid argument"},
+		{"x/\xff", "not valid utf-8: invalid argument"},
+	}
+
+	for _, tc := range testCases {
+		err := ValidatePath(tc.path)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("ValidatePath(%q): got error %q, wanted none", tc.path, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("ValidatePath(%q): got no error, wanted %q", tc.path, tc.want)
+			continue
+		}
+		if err.Error() != tc.want {
+			t.Errorf("ValidatePath(%q): got error %q, wanted %q", tc.path, err, tc.want)
+		}
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("ValidatePath(%q): error does not wrap %q", tc.path, fs.ErrInvalid)
+		}
+	}
+
+	fsys := buildFS(t, mdtest.Mock(), nil)
+	_, err := fsys.ReadDir("/x")
+	if err == nil || err.Error() != "readdir /x: leading slash: invalid argument" {
+		t.Errorf("readdir: got error %q, wanted descriptive error", err)
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"x/file": []byte("file content"),
+	})
+
+	_, err := fsys.Open("x/")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("open: got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+
+	_, err = fsys.Sub("x/")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("sub: got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+
+	_, err = fsys.ReadDir("x/")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("readdir: got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestMaxPathDepth(t *testing.T) {
+	ds := mdtest.Mock()
+
+	// Build a root with a chain of DefaultMaxPathDepth directories each named "d" below it and a
+	// file at the bottom
+	child := utest.GetNode(t, ds, []byte("deep"), utest.UseCidV1)
+	childName := "file"
+	for i := 0; i <= DefaultMaxPathDepth; i++ {
+		child = buildRootNode(t, ds, nil, map[string]ipld.Node{childName: child})
+		childName = "d"
+	}
+	fsys := mustReadFS(t, child, ds)
+
+	dirPath := strings.Repeat("d/", DefaultMaxPathDepth-1) + "d"
+	filePath := dirPath + "/file"
+
+	f, err := fsys.Open(dirPath)
+	if err != nil {
+		t.Fatalf("failed to open path at the depth limit: %v", err)
+	}
+	f.Close()
+
+	_, err = fsys.Open(filePath)
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("open path over the depth limit: got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+
+	shallow := fsys.WithMaxPathDepth(10)
+	f, err = shallow.Open(strings.Repeat("d/", 9) + "d")
+	if err != nil {
+		t.Fatalf("failed to open path at the depth limit: %v", err)
+	}
+	f.Close()
+
+	_, err = shallow.Open(strings.Repeat("d/", 10) + "d")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("open path over the depth limit: got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestNotUnixFSDirectory(t *testing.T) {
+	ds := mdtest.Mock()
+	cbornode, err := cbor.WrapObject(map[string]string{"hello": "world"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatalf("failed to create cbor node: %v", err)
+	}
+	fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{"doc": cbornode})
+
+	check := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("%s: got %q error, wanted %q", op, err, fs.ErrInvalid)
+		}
+		if err == nil || !strings.Contains(err.Error(), "not a UnixFS directory") {
+			t.Errorf("%s: got error %q, wanted it to report the node is not a UnixFS directory", op, err)
+		}
+	}
+
+	_, err = fsys.Sub("doc")
+	check("sub", err)
+
+	_, err = fsys.ReadDir("doc")
+	check("readdir", err)
+
+	_, err = fsys.Open("doc/hello")
+	check("open", err)
+
+	_, err = ReadFS(cbornode, ds)
+	check("readfs", err)
+}
+
+func FuzzOpen(f *testing.F) {
+	fsys := buildFS(f, mdtest.Mock(), map[string][]byte{
+		"hello.txt":   []byte("hello"),
+		"a/b/c/file":  []byte("file content"),
+		"a/b/c/empty": nil, // empty dir
+	})
+
+	for _, seed := range []string{".", "", "/", "//", "a", "a/", "/a", "a//b", "a/b/c/file", "a/../a", "..", "hello.txt/x", "\x00", "a/\xff"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, path string) {
+		if valid := ValidatePath(path) == nil; valid != fs.ValidPath(path) {
+			t.Errorf("ValidatePath(%q) reported valid=%v, fs.ValidPath disagrees", path, valid)
+		}
+
+		file, err := fsys.Open(path)
+		if err == nil {
+			file.Close()
+			if !fs.ValidPath(path) {
+				t.Errorf("opened invalid path %q", path)
+			}
+		} else if !errors.Is(err, fs.ErrInvalid) && !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("open %q: got %q error, wanted %q or %q", path, err, fs.ErrInvalid, fs.ErrNotExist)
+		}
+
+		if _, err := fsys.ReadDir(path); err != nil && !errors.Is(err, fs.ErrInvalid) && !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("readdir %q: got %q error, wanted %q or %q", path, err, fs.ErrInvalid, fs.ErrNotExist)
+		}
+
+		if _, err := fsys.Sub(path); err != nil && !errors.Is(err, fs.ErrInvalid) && !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("sub %q: got %q error, wanted %q or %q", path, err, fs.ErrInvalid, fs.ErrNotExist)
+		}
+	})
+}
+
+func TestDecompressedReader(t *testing.T) {
+	content := bytes.Repeat([]byte("compressible content "), 100)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"file.gz":  buf.Bytes(),
+		"file.txt": content,
+		"short":    {0x1f},
+	})
+
+	testCases := []struct {
+		name string
+		want []byte
+	}{
+		{name: "file.gz", want: content},
+		{name: "file.txt", want: content},
+		{name: "short", want: []byte{0x1f}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fsys.Open(tc.name)
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			defer f.Close()
+
+			rc, err := f.(*File).DecompressedReader()
+			if err != nil {
+				t.Fatalf("failed to get decompressed reader: %v", err)
+			}
+			defer rc.Close()
+
+			data, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if !bytes.Equal(data, tc.want) {
+				t.Errorf("got %d bytes, wanted %d bytes", len(data), len(tc.want))
+			}
+		})
+	}
+}
+
+func TestManifest(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"hello.txt":     []byte("hello"),
+		"a/b/file1":     []byte("file1"),
+		"a/b/file2.txt": []byte("file2 content"),
+		"a/c":           nil, // empty dir
+	})
+
+	entries, err := fsys.Manifest("a")
+	if err != nil {
+		t.Fatalf("failed to get manifest: %v", err)
+	}
+
+	type summary struct {
+		Path string
+		Size int64
+		Type fs.FileMode
+	}
+
+	got := make([]summary, len(entries))
+	for i, e := range entries {
+		got[i] = summary{Path: e.Path, Size: e.Size, Type: e.Type}
+
+		info, err := fs.Stat(fsys, e.Path)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", e.Path, err)
+		}
+		if c := info.(*FileInfo).Cid(); c != e.Cid {
+			t.Errorf("%s: got cid %s, wanted %s", e.Path, e.Cid, c)
+		}
+	}
+
+	want := []summary{
+		{Path: "a/b", Size: 228, Type: fs.ModeDir},
+		{Path: "a/b/file1", Size: 5},
+		{Path: "a/b/file2.txt", Size: 13},
+		{Path: "a/c", Size: 4, Type: fs.ModeDir},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Manifest() mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = fsys.Manifest("a/")
+	var pe *fs.PathError
+	if !errors.As(err, &pe) || pe.Op != "manifest" || !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error for invalid path, wanted manifest path error wrapping %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestWritePathList(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"hello.txt":     []byte("hello"),
+		"a/b/file1":     []byte("file1"),
+		"a/b/file2.txt": []byte("file2 content"),
+		"a/c/empty":     nil, // empty dir
+	})
+
+	var buf bytes.Buffer
+	if err := fsys.WritePathList(context.Background(), ".", &buf); err != nil {
+		t.Fatalf("failed to write path list: %v", err)
+	}
+
+	want := "a\na/b\na/b/file1\na/b/file2.txt\na/c\na/c/empty\nhello.txt\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got path list %q, wanted %q", got, want)
+	}
+
+	buf.Reset()
+	if err := fsys.WritePathList(context.Background(), "a/b", &buf); err != nil {
+		t.Fatalf("failed to write path list: %v", err)
+	}
+	if got, want := buf.String(), "a/b/file1\na/b/file2.txt\n"; got != want {
+		t.Errorf("got path list %q, wanted %q", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fsys.WritePathList(ctx, ".", io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %q error, wanted %q", err, context.Canceled)
+	}
+
+	err := fsys.WritePathList(context.Background(), "a/./b", io.Discard)
+	var pe *fs.PathError
+	if !errors.As(err, &pe) || pe.Op != "pathlist" || !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error for invalid path, wanted pathlist path error wrapping %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestIsInline(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFSWithNodes(t, ds, map[string][]byte{
+		"multi": bytes.Repeat([]byte("0123456789"), 200),
+	}, map[string]ipld.Node{
+		"inline": merkledag.NodeWithData(ufs.FilePBData([]byte("inline content"), uint64(len("inline content")))),
+		"raw":    merkledag.NewRawNode([]byte("raw content")),
+	})
+
+	testCases := []struct {
+		name string
+		want bool
+	}{
+		{"inline", true},
+		{"raw", true},
+		{"multi", false},
+	}
+
+	for _, tc := range testCases {
+		f, err := fsys.Open(tc.name)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", tc.name, err)
+		}
+		if got := f.(*File).IsInline(); got != tc.want {
+			t.Errorf("%s: got IsInline() %v, wanted %v", tc.name, got, tc.want)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", tc.name, err)
+		}
+		if got := f.(*File).Size(); got != fi.Size() {
+			t.Errorf("%s: got Size() %d, wanted %d", tc.name, got, fi.Size())
+		}
+		f.Close()
+	}
+}
+
+func TestOpenUnixFSRawFile(t *testing.T) {
+	ds := mdtest.Mock()
+	content := []byte("raw unixfs content")
+	raw := merkledag.NodeWithData(ufs.WrapData(content))
+	fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{"raw": raw})
+
+	fi, err := fs.Stat(fsys, "raw")
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if fi.Size() != int64(len(content)) {
+		t.Errorf("got size %d, wanted %d", fi.Size(), len(content))
+	}
+	if !fi.Mode().IsRegular() {
+		t.Errorf("got mode %v, wanted a regular file", fi.Mode())
+	}
+	if !fi.(*FileInfo).IsUnixFS() {
+		t.Errorf("got IsUnixFS() false, wanted true")
+	}
+
+	data, err := fs.ReadFile(fsys, "raw")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got data %q, wanted %q", data, content)
+	}
+}
+
+func TestOpenRawLeafFile(t *testing.T) {
+	ds := mdtest.Mock()
+	small := []byte("raw leaf content")
+	leaf := merkledag.NewRawNode(small)
+
+	// a multi-block file whose leaves are all raw blocks
+	large := bytes.Repeat([]byte("0123456789"), 100000)
+
+	fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{
+		"small": leaf,
+		"large": utest.GetNode(t, ds, large, utest.UseCidV1),
+	})
+
+	for name, content := range map[string][]byte{"small": small, "large": large} {
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", name, err)
+		}
+		if fi.Size() != int64(len(content)) {
+			t.Errorf("%s: got size %d, wanted %d", name, fi.Size(), len(content))
+		}
+		if fi.Mode() != 0o644 {
+			t.Errorf("%s: got mode %v, wanted %v", name, fi.Mode(), fs.FileMode(0o644))
+		}
+		if !fi.(*FileInfo).IsUnixFS() {
+			t.Errorf("%s: got IsUnixFS() false, wanted true", name)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("%s: got %d bytes of content, wanted %d", name, len(data), len(content))
+		}
+	}
+}
+
+func TestFileBlockCids(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock()
+	content := make([]byte, 2<<20)
+	rand.New(rand.NewSource(3)).Read(content)
+
+	// leafData returns the file content held by a leaf block
+	leafData := func(t *testing.T, c cid.Cid) []byte {
+		t.Helper()
+		nd, err := ds.Get(ctx, c)
+		if err != nil {
+			t.Fatalf("failed to get leaf %s: %v", c, err)
+		}
+		pn, ok := nd.(*merkledag.ProtoNode)
+		if !ok {
+			return nd.RawData()
+		}
+		fsn, err := ufs.FSNodeFromBytes(pn.Data())
+		if err != nil {
+			t.Fatalf("failed to decode leaf %s: %v", c, err)
+		}
+		return fsn.Data()
+	}
+
+	testCases := []struct {
+		name string
+		opts utest.NodeOpts
+	}{
+		{name: "raw leaves", opts: utest.UseRawLeaves},
+		{name: "protobuf leaves", opts: utest.UseProtoBufLeaves},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			single := merkledag.NewRawNode([]byte("single"))
+			dirnode := buildRootNode(t, ds, nil, map[string]ipld.Node{
+				"large":  utest.GetNode(t, ds, content, tc.opts),
+				"single": single,
+			})
+			cg := &countingGetter{NodeGetter: ds}
+			fsys := mustReadFS(t, dirnode, cg)
+
+			f, err := fsys.Open("large")
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			defer f.Close()
+
+			before := cg.count()
+			leaves, err := f.(*File).BlockCids(false)
+			if err != nil {
+				t.Fatalf("BlockCids: %v", err)
+			}
+			fetched := cg.count() - before
+
+			var got []byte
+			for _, c := range leaves {
+				got = append(got, leafData(t, c)...)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("leaf content does not match file content")
+			}
+
+			all, err := f.(*File).BlockCids(true)
+			if err != nil {
+				t.Fatalf("BlockCids: %v", err)
+			}
+			if !all[0].Equals(f.(*File).Cid()) {
+				t.Errorf("got first cid %s, wanted root %s", all[0], f.(*File).Cid())
+			}
+			intermediates := len(all) - len(leaves)
+			if intermediates < 2 {
+				t.Errorf("got %d intermediate nodes, wanted a multi-level DAG", intermediates)
+			}
+
+			// only the intermediate nodes below the root are loaded when leaves are raw blocks
+			if tc.opts.ForceRawLeaves && fetched != intermediates-1 {
+				t.Errorf("got %d node requests, wanted %d", fetched, intermediates-1)
+			}
+
+			sf, err := fsys.Open("single")
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			defer sf.Close()
+			cids, err := sf.(*File).BlockCids(true)
+			if err != nil {
+				t.Fatalf("BlockCids: %v", err)
+			}
+			if len(cids) != 1 || !cids[0].Equals(single.Cid()) {
+				t.Errorf("got %v, wanted only the root cid %s", cids, single.Cid())
+			}
+		})
+	}
+}
+
+func TestFileBlockCidsRootWithData(t *testing.T) {
+	ds := mdtest.Mock()
+	tail := merkledag.NewRawNode([]byte("tail"))
+	if err := ds.Add(context.Background(), tail); err != nil {
+		t.Fatalf("failed to add leaf: %v", err)
+	}
+
+	// The root carries data of its own as well as linking to a leaf
+	fsn := ufs.NewFSNode(ufs.TFile)
+	fsn.SetData([]byte("head"))
+	fsn.AddBlockSize(uint64(len(tail.RawData())))
+	fdata, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatalf("failed to get file data: %v", err)
+	}
+	root := merkledag.NodeWithData(fdata)
+	if err := root.AddNodeLink("", tail); err != nil {
+		t.Fatalf("failed to link leaf: %v", err)
+	}
+
+	fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{"file": root})
+
+	// The data of an internal node is not part of the file's content
+	if data, err := fsys.ReadFile("file"); err != nil || string(data) != "tail" {
+		t.Fatalf("got content %q, %v, wanted %q", data, err, "tail")
+	}
+
+	f, err := fsys.Open("file")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	testCases := []struct {
+		intermediate bool
+		want         []cid.Cid
+	}{
+		{intermediate: false, want: []cid.Cid{tail.Cid()}},
+		{intermediate: true, want: []cid.Cid{root.Cid(), tail.Cid()}},
+	}
+	for _, tc := range testCases {
+		got, err := f.(*File).BlockCids(tc.intermediate)
+		if err != nil {
+			t.Fatalf("BlockCids(%v): %v", tc.intermediate, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("BlockCids(%v): got %v, wanted %v", tc.intermediate, got, tc.want)
+		}
+	}
+}
+
+func TestWithURLUnescape(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"my file":     []byte("spaced"),
+		"my dir/file": []byte("nested"),
+		"100%":        []byte("percent"),
+		"a b":         []byte("unescaped"),
+		"a%20b":       []byte("literal"),
+		"x y":         []byte("once"),
+	})
+
+	if _, err := fsys.Open("my%20file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %q error without unescaping, wanted %q", err, fs.ErrNotExist)
+	}
+
+	ufsys := fsys.WithURLUnescape()
+	testCases := []struct {
+		path string
+		want string
+	}{
+		{path: "my%20file", want: "spaced"},
+		{path: "my file", want: "spaced"},
+		{path: "my%20dir/file", want: "nested"},
+		{path: "100%", want: "percent"},
+		{path: "a%20b", want: "literal"}, // literal match is preferred
+		{path: "a b", want: "unescaped"},
+	}
+
+	for _, tc := range testCases {
+		data, err := fs.ReadFile(ufsys, tc.path)
+		if err != nil {
+			t.Errorf("failed to read %q: %v", tc.path, err)
+			continue
+		}
+		if string(data) != tc.want {
+			t.Errorf("%q: got data %q, wanted %q", tc.path, data, tc.want)
+		}
+	}
+
+	// Names are only unescaped once
+	if _, err := ufsys.Open("x%2520y"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %q error for doubly escaped name, wanted %q", err, fs.ErrNotExist)
+	}
+}
+
+func TestRootLinks(t *testing.T) {
+	ds := mdtest.Mock()
+	raw := merkledag.NewRawNode([]byte("raw content"))
+	dirnode := buildRootNode(t, ds, map[string][]byte{
+		"a/file": []byte("file content"),
+		"b.txt":  []byte("b"),
+	}, map[string]ipld.Node{
+		"raw": raw,
+	})
+
+	// Only the root node is available
+	partial := mdtest.Mock()
+	if err := partial.Add(context.Background(), dirnode); err != nil {
+		t.Fatalf("failed to add root node: %v", err)
+	}
+	fsys := mustReadFS(t, dirnode, partial)
+
+	links, err := fsys.RootLinks()
+	if err != nil {
+		t.Fatalf("failed to get root links: %v", err)
+	}
+
+	var got []string
+	for _, l := range links {
+		got = append(got, fmt.Sprintf("%s %d", l.Name, l.Type))
+		if !l.Cid.Defined() {
+			t.Errorf("%s: got undefined cid", l.Name)
+		}
+	}
+	want := []string{
+		fmt.Sprintf("a %d", LinkTypeUnknown),
+		fmt.Sprintf("b.txt %d", LinkTypeUnknown),
+		fmt.Sprintf("raw %d", LinkTypeFile),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("RootLinks() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWalkDirSkip(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/b/file1": []byte("file1"),
+		"a/c/file2": []byte("file2"),
+		"d/file3":   []byte("file3"),
+	})
+
+	testCases := []struct {
+		name string
+		skip func(path string, d fs.DirEntry) error
+		want []string
+	}{
+		{
+			name: "skipdir",
+			skip: func(path string, d fs.DirEntry) error {
+				if path == "a/b" {
+					return fs.SkipDir
+				}
+				return nil
+			},
+			want: []string{".", "a", "a/b", "a/c", "a/c/file2", "d", "d/file3"},
+		},
+		{
+			name: "skipdir_file",
+			skip: func(path string, d fs.DirEntry) error {
+				if path == "a/c/file2" {
+					// skips the remaining entries of the parent directory
+					return fs.SkipDir
+				}
+				return nil
+			},
+			want: []string{".", "a", "a/b", "a/b/file1", "a/c", "a/c/file2", "d", "d/file3"},
+		},
+		{
+			name: "skipall",
+			skip: func(path string, d fs.DirEntry) error {
+				if path == "a/c" {
+					return fs.SkipAll
+				}
+				return nil
+			},
+			want: []string{".", "a", "a/b", "a/b/file1", "a/c"},
+		},
+	}
+
+	walkers := map[string]func(root string, fn fs.WalkDirFunc) error{
+		"walkdir": func(root string, fn fs.WalkDirFunc) error { return fs.WalkDir(fsys, root, fn) },
+		"walk":    fsys.Walk,
+	}
+
+	for _, tc := range testCases {
+		for wname, walk := range walkers {
+			t.Run(tc.name+"_"+wname, func(t *testing.T) {
+				var got []string
+				err := walk(".", func(path string, d fs.DirEntry, err error) error {
+					if err != nil {
+						return err
+					}
+					got = append(got, path)
+					return tc.skip(path, d)
+				})
+				if err != nil {
+					t.Fatalf("failed to walk: %v", err)
+				}
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Errorf("walked paths mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	}
+
+	var got []string
+	if err := fsys.Walk("a", func(path string, d fs.DirEntry, err error) error {
+		got = append(got, path)
+		return err
+	}); err != nil {
+		t.Fatalf("failed to walk subtree: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a", "a/b", "a/b/file1", "a/c", "a/c/file2"}, got); diff != "" {
+		t.Errorf("walked subtree paths mismatch (-want +got):\n%s", diff)
+	}
+
+	err := fsys.Walk("missing", func(path string, d fs.DirEntry, err error) error { return err })
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v walking a missing root, wanted %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	content := bytes.Repeat([]byte("read file content "), 50000)
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/large": content,
+		"small":   []byte("small"),
+		"empty":   {},
+	})
+
+	for name, want := range map[string][]byte{"a/large": content, "small": []byte("small"), "empty": {}} {
+		got, err := fsys.ReadFile(name)
+		if err != nil {
+			t.Errorf("%s: failed to read file: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes not matching content of %d bytes", name, len(got), len(want))
+		}
+	}
+
+	if _, err := fsys.ReadFile("a"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v reading a directory, wanted %v", err, fs.ErrInvalid)
+	}
+	if _, err := fsys.ReadFile("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v reading a missing file, wanted %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestRangeReader(t *testing.T) {
+	content := make([]byte, 5000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"file": content,
+	})
+
+	f, err := fsys.Open("file")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	file := f.(*File)
+
+	testCases := []struct {
+		start, length int64
+	}{
+		{0, 10},
+		{1234, 600}, // spans several blocks
+		{4990, 10},
+		{5000, 0},
+		{0, 5000},
+	}
+
+	for _, tc := range testCases {
+		r, err := file.RangeReader(tc.start, tc.length)
+		if err != nil {
+			t.Errorf("range %d+%d: unexpected error: %v", tc.start, tc.length, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("range %d+%d: failed to read: %v", tc.start, tc.length, err)
+			continue
+		}
+		if want := content[tc.start : tc.start+tc.length]; !bytes.Equal(got, want) {
+			t.Errorf("range %d+%d: got %d bytes not matching content", tc.start, tc.length, len(got))
+		}
+		if r.(*rangeReader).dr != nil {
+			t.Errorf("range %d+%d: reader was not released after reading the whole range", tc.start, tc.length)
+		}
+	}
+
+	// A reader closed before the end of the range releases its resources
+	r, err := file.RangeReader(100, 1000)
+	if err != nil {
+		t.Fatalf("failed to get range reader: %v", err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatalf("failed to read range: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("failed to close range reader: %v", err)
+	}
+	if r.(*rangeReader).dr != nil {
+		t.Errorf("reader was not released by Close")
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("got %d bytes and error %v reading a closed range reader, wanted 0 and %v", n, err, io.EOF)
+	}
+
+	invalid := []struct {
+		start, length int64
+	}{
+		{-1, 1},
+		{0, -1},
+		{4999, 2},
+		{5001, 0},
+	}
+	for _, tc := range invalid {
+		if _, err := file.RangeReader(tc.start, tc.length); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("range %d+%d: got %q error, wanted %q", tc.start, tc.length, err, ErrInvalidRange)
+		}
+	}
+
+	// The file's own position is not affected
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(file, buf); err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(buf, content[:10]) {
+		t.Errorf("got data %v from start of file, wanted %v", buf, content[:10])
+	}
+}
+
+func TestNotDirErrors(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a":       []byte("a file"),
+		"dir/b":   []byte("another file"),
+		"dir/sub": nil, // empty dir
+	})
+
+	testCases := []struct {
+		name    string
+		op      func() error
+		notDir  bool
+		missing bool
+	}{
+		{name: "open through file", op: func() error { _, err := fsys.Open("a/b"); return err }, notDir: true},
+		{name: "open deep through file", op: func() error { _, err := fsys.Open("dir/b/c/d"); return err }, notDir: true},
+		{name: "stat through file", op: func() error { _, err := fsys.Stat("a/b"); return err }, notDir: true},
+		{name: "readdir through file", op: func() error { _, err := fsys.ReadDir("a/b"); return err }, notDir: true},
+		{name: "readdir of file", op: func() error { _, err := fsys.ReadDir("a"); return err }, notDir: true},
+		{name: "sub of file", op: func() error { _, err := fsys.Sub("dir/b"); return err }, notDir: true},
+		{name: "open missing", op: func() error { _, err := fsys.Open("missing/b"); return err }, missing: true},
+		{name: "open missing in dir", op: func() error { _, err := fsys.Open("dir/sub/b"); return err }, missing: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.op()
+			if err == nil {
+				t.Fatal("got no error, wanted one")
+			}
+			if got := errors.Is(err, syscall.ENOTDIR); got != tc.notDir {
+				t.Errorf("got errors.Is(err, syscall.ENOTDIR) %v, wanted %v for error %v", got, tc.notDir, err)
+			}
+			if got := errors.Is(err, fs.ErrNotExist); got != tc.missing {
+				t.Errorf("got errors.Is(err, fs.ErrNotExist) %v, wanted %v for error %v", got, tc.missing, err)
+			}
+			if tc.notDir && !errors.Is(err, fs.ErrInvalid) {
+				t.Errorf("got error %v, wanted one also wrapping %v", err, fs.ErrInvalid)
+			}
+		})
+	}
+}
+
+func TestCidEntry(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file":        []byte("file content"),
+		"a/b/nested":    []byte("nested"),
+		"top-level.txt": []byte("top"),
+	})
+
+	type pathCid struct {
+		path string
+		cid  cid.Cid
+	}
+	var got []pathCid
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		ce, ok := d.(CidEntry)
+		if !ok {
+			return fmt.Errorf("%s: entry of type %T does not implement CidEntry", p, d)
+		}
+		got = append(got, pathCid{path: p, cid: ce.Cid()})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+
+	wantPaths := []string{"a", "a/b", "a/b/nested", "a/file", "top-level.txt"}
+	if len(got) != len(wantPaths) {
+		t.Fatalf("got %d entries, wanted %d", len(got), len(wantPaths))
+	}
+	for i, pc := range got {
+		if pc.path != wantPaths[i] {
+			t.Errorf("entry %d: got path %q, wanted %q", i, pc.path, wantPaths[i])
+		}
+
+		f, err := fsys.Open(pc.path)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", pc.path, err)
+		}
+		ce, ok := f.(CidEntry)
+		if !ok {
+			t.Fatalf("Open(%q): file of type %T does not implement CidEntry", pc.path, f)
+		}
+		if want := ce.Cid(); !pc.cid.Equals(want) {
+			t.Errorf("%s: got cid %s from entry, wanted %s from opened file", pc.path, pc.cid, want)
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Stat(%q): %v", pc.path, err)
+		}
+		if want := info.(CidEntry).Cid(); !pc.cid.Equals(want) {
+			t.Errorf("%s: got cid %s from entry, wanted %s from file info", pc.path, pc.cid, want)
+		}
+		f.Close()
+	}
+}
+
+func TestExists(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file":        []byte("file content"),
+		"a/large.bin":   bytes.Repeat([]byte("0123456789"), 1000),
+		"a/empty":       nil, // empty dir
+		"top-level.txt": []byte("top"),
+	})
+
+	testCases := []struct {
+		path string
+		want bool
+	}{
+		{path: ".", want: true},
+		{path: "a", want: true},
+		{path: "a/file", want: true},
+		{path: "a/empty", want: true},
+		{path: "top-level.txt", want: true},
+		{path: "missing", want: false},
+		{path: "a/missing", want: false},
+		{path: "missing/file", want: false},
+		{path: "a/file/child", want: false},
+		{path: "top-level.txt/a/b", want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := fsys.Exists(tc.path)
+			if err != nil {
+				t.Fatalf("Exists: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %v, wanted %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("leaves not loaded", func(t *testing.T) {
+		cg := &countingGetter{NodeGetter: ds}
+		cfs := mustReadFS(t, fsys.root, cg)
+		if ok, err := cfs.Exists("a/large.bin"); err != nil || !ok {
+			t.Fatalf("got %v, %v, wanted true with no error", ok, err)
+		}
+		// only the directory and the root of the file are loaded
+		if got := cg.count(); got != 2 {
+			t.Errorf("got %d node requests, wanted 2", got)
+		}
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		if _, err := fsys.Exists("/a"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, fs.ErrInvalid)
+		}
+	})
+
+	t.Run("load failure", func(t *testing.T) {
+		efs := mustReadFS(t, fsys.root, &errGetter{err: errors.New("network down")})
+		if _, err := efs.Exists("a/file"); err == nil {
+			t.Errorf("got no error, wanted the getter's error")
+		}
+	})
+}
+
+func TestSymlinks(t *testing.T) {
+	fsys := symlinkFS(t, map[string]string{
+		"link":    "a/file",
+		"dirlink": "a",
+		"loop1":   "loop2",
+		"loop2":   "loop1",
+		"abs":     "/a/file",
+		"escape":  "../a/file",
+		"dangle":  "missing",
+		"empty":   "",
+	})
+
+	readTests := []struct {
+		path string
+		want string
+	}{
+		{path: "link", want: "file content"},
+		{path: "a/sib", want: "file content"},
+		{path: "a/up", want: "b content"},
+		{path: "dirlink/file", want: "file content"},
+		{path: "dirlink/sib", want: "file content"},
+		{path: "dirlink/up", want: "b content"},
+	}
+	for _, tc := range readTests {
+		data, err := fs.ReadFile(fsys, tc.path)
+		if err != nil {
+			t.Errorf("%s: failed to read file: %v", tc.path, err)
+			continue
+		}
+		if string(data) != tc.want {
+			t.Errorf("%s: got data %q, wanted %q", tc.path, data, tc.want)
+		}
+	}
+
+	fi, err := fs.Stat(fsys, "link")
+	if err != nil {
+		t.Fatalf("failed to stat link: %v", err)
+	}
+	if fi.Name() != "link" || !fi.Mode().IsRegular() {
+		t.Errorf("got name %q and mode %v, wanted the link name and a regular file", fi.Name(), fi.Mode())
+	}
+
+	linkTests := []struct {
+		path string
+		want string
+	}{
+		{path: "link", want: "a/file"},
+		{path: "a/up", want: "../b"},
+		{path: "dirlink/sib", want: "file"},
+	}
+	for _, tc := range linkTests {
+		got, err := fsys.ReadLink(tc.path)
+		if err != nil {
+			t.Errorf("%s: failed to read link: %v", tc.path, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got target %q, wanted %q", tc.path, got, tc.want)
+		}
+	}
+	if _, err := fsys.ReadLink("a/file"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v reading a file as a link, wanted %v", err, fs.ErrInvalid)
+	}
+
+	for _, name := range []string{"loop1", "abs", "escape"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("%s: got error %v, wanted %v", name, err, fs.ErrInvalid)
+		}
+	}
+	for _, name := range []string{"dangle", "empty", "empty/a/file"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: got error %v, wanted %v", name, err, fs.ErrNotExist)
+		}
+	}
+
+	limited := fsys.WithMaxSymlinkHops(1)
+	if _, err := limited.Open("link"); err != nil {
+		t.Errorf("got error %v following a single link, wanted none", err)
+	}
+	if _, err := limited.Open("dirlink/sib"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v following two links, wanted %v", err, fs.ErrInvalid)
+	}
+
+	entries, err := fsys.ReadDir(".")
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	for _, e := range entries {
+		if e.Name() != "link" {
+			continue
+		}
+		if e.Type() != fs.ModeSymlink {
+			t.Errorf("got link entry type %v, wanted %v", e.Type(), fs.ModeSymlink)
+		}
+		info, err := e.Info()
+		if err != nil {
+			t.Fatalf("failed to get link entry info: %v", err)
+		}
+		if info.Size() != int64(len("a/file")) {
+			t.Errorf("got link entry size %d, wanted %d", info.Size(), len("a/file"))
+		}
+	}
+}
+
+func TestSymlinksArchiveAndExtract(t *testing.T) {
+	fsys := symlinkFS(t, map[string]string{
+		"link":    "a/file",
+		"dirlink": "a",
+	})
+
+	var buf bytes.Buffer
+	if err := fsys.WriteTarGz(context.Background(), &buf, gzip.BestSpeed); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to read gzip stream: %v", err)
+	}
+	tr := tar.NewReader(zr)
+	links := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar entry: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			links[hdr.Name] = hdr.Linkname
+		}
+	}
+	wantLinks := map[string]string{"link": "a/file", "dirlink": "a", "a/sib": "file", "a/up": "../b"}
+	if diff := cmp.Diff(wantLinks, links); diff != "" {
+		t.Errorf("archive symlinks mismatch (-want +got):\n%s", diff)
+	}
+
+	dest := t.TempDir()
+	if err := fsys.ExtractTo(context.Background(), dest); err != nil {
+		t.Fatalf("failed to extract: %v", err)
+	}
+	for name, target := range wantLinks {
+		got, err := os.Readlink(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s: failed to read extracted link: %v", name, err)
+			continue
+		}
+		if got != target {
+			t.Errorf("%s: got extracted target %q, wanted %q", name, got, target)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "dirlink", "up"))
+	if err != nil {
+		t.Fatalf("failed to read through extracted links: %v", err)
+	}
+	if string(data) != "b content" {
+		t.Errorf("got data %q, wanted %q", data, "b content")
+	}
+
+	escaping := symlinkFS(t, map[string]string{"escape": "../outside"})
+	if err := escaping.ExtractTo(context.Background(), t.TempDir()); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v extracting an escaping link, wanted %v", err, fs.ErrInvalid)
+	}
+}
+
+// symlinkFS returns an FS holding a directory "a" containing "file", a symbolic link "sib" to
+// "file" and a symbolic link "up" to "../b", a file "b" and the supplied symbolic links.
+func symlinkFS(t *testing.T, links map[string]string) *FS {
+	t.Helper()
+	ds := mdtest.Mock()
+
+	symlink := func(target string) ipld.Node {
+		t.Helper()
+		data, err := ufs.SymlinkData(target)
+		if err != nil {
+			t.Fatalf("failed to create symlink data: %v", err)
+		}
+		return merkledag.NodeWithData(data)
+	}
+
+	anode := buildRootNode(t, ds, map[string][]byte{
+		"file": []byte("file content"),
+	}, map[string]ipld.Node{
+		"sib": symlink("file"),
+		"up":  symlink("../b"),
+	})
+
+	nodes := map[string]ipld.Node{"a": anode}
+	for name, target := range links {
+		nodes[name] = symlink(target)
+	}
+	return buildFSWithNodes(t, ds, map[string][]byte{
+		"b": []byte("b content"),
+	}, nodes)
+}
+
+func TestDirRawData(t *testing.T) {
+	testCases := []struct {
+		name string
+		fsys func(t *testing.T, ds ipld.DAGService) *FS
+	}{
+		{
+			name: "basic",
+			fsys: func(t *testing.T, ds ipld.DAGService) *FS {
+				return buildFS(t, ds, map[string][]byte{
+					"a/file": []byte("file content"),
+					"b":      []byte("file content"),
+				})
+			},
+		},
+		{
+			name: "sharded",
+			fsys: func(t *testing.T, ds ipld.DAGService) *FS {
+				return shardedFS(t, []string{"a", "b", "c", "d"})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := tc.fsys(t, mdtest.Mock())
+
+			f, err := fsys.Open(".")
+			if err != nil {
+				t.Fatalf("failed to open root: %v", err)
+			}
+			defer f.Close()
+
+			d := f.(*Dir)
+			data, err := d.RawData()
+			if err != nil {
+				t.Fatalf("failed to get raw data: %v", err)
+			}
+			if !bytes.Equal(data, fsys.root.RawData()) {
+				t.Errorf("raw data does not match root node")
+			}
+
+			want := d.info.node.Cid()
+			got, err := want.Prefix().Sum(data)
+			if err != nil {
+				t.Fatalf("failed to hash raw data: %v", err)
+			}
+			if !got.Equals(want) {
+				t.Errorf("got raw data hashing to %s, wanted %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDirEntryAt(t *testing.T) {
+	names := []string{"delta", "alpha", "echo", "charlie", "bravo"}
+	sorted := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+
+	basic := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"alpha": []byte("a"), "bravo": []byte("b"), "charlie": []byte("c"), "delta": []byte("d"), "echo": []byte("e"),
+	})
+	sharded := shardedFS(t, names)
+
+	for name, fsys := range map[string]*FS{"basic": basic, "sharded": sharded} {
+		t.Run(name, func(t *testing.T) {
+			f, err := fsys.Open(".")
+			if err != nil {
+				t.Fatalf("failed to open root: %v", err)
+			}
+			defer f.Close()
+			d := f.(*Dir)
+
+			for _, i := range []int{3, 0, 4, 1, 2} {
+				e, err := d.EntryAt(i)
+				if err != nil {
+					t.Fatalf("EntryAt(%d): unexpected error: %v", i, err)
+				}
+				if e.Name() != sorted[i] {
+					t.Errorf("EntryAt(%d): got name %q, wanted %q", i, e.Name(), sorted[i])
+				}
+			}
+
+			for _, i := range []int{-1, len(sorted)} {
+				if _, err := d.EntryAt(i); !errors.Is(err, fs.ErrInvalid) {
+					t.Errorf("EntryAt(%d): got %q error, wanted %q", i, err, fs.ErrInvalid)
+				}
+			}
+		})
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	content := make([]byte, 300000)
+	rand.New(rand.NewSource(7)).Read(content)
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"file": content,
+	})
+
+	f, err := fsys.Open("file")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	file := f.(*File)
+
+	// Concurrent reads at independent offsets spanning chunk boundaries
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			buf := make([]byte, 1000)
+			n, err := file.ReadAt(buf, off)
+			if err != nil {
+				errs <- fmt.Errorf("read at %d: %w", off, err)
+				return
+			}
+			if !bytes.Equal(buf[:n], content[off:off+1000]) {
+				errs <- fmt.Errorf("read at %d: data does not match content", off)
+			}
+		}(int64(i) * 18000)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// A read past the end returns the available bytes with io.EOF
+	buf := make([]byte, 100)
+	n, err := file.ReadAt(buf, int64(len(content)-40))
+	if n != 40 || err != io.EOF {
+		t.Errorf("got %d bytes and error %v reading past the end, wanted 40 and io.EOF", n, err)
+	}
+	if n, err := file.ReadAt(buf, int64(len(content))); n != 0 || err != io.EOF {
+		t.Errorf("got %d bytes and error %v reading at the end, wanted 0 and io.EOF", n, err)
+	}
+	if _, err := file.ReadAt(buf, -1); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v for negative offset, wanted %v", err, fs.ErrInvalid)
+	}
+
+	// ReadAt does not disturb the read position
+	data, err := io.ReadAll(io.NewSectionReader(file, 1000, 5000))
+	if err != nil {
+		t.Fatalf("failed to read section: %v", err)
+	}
+	if !bytes.Equal(data, content[1000:6000]) {
+		t.Errorf("section data does not match content")
+	}
+	data, err = io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %d bytes of data not matching content", len(data))
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	ds := mdtest.Mock()
+	content := bytes.Repeat([]byte("same content "), 200)
+	different := bytes.Repeat([]byte("diff content "), 200)
+
+	dirnode := buildRootNode(t, ds, map[string][]byte{
+		"a/file": content,
+		"b/file": content,
+		"diff":   different,
+	}, map[string]ipld.Node{
+		// The same content chunked with protobuf leaves has a different CID
+		"pbleaves": utest.GetNode(t, ds, content, utest.UseProtoBufLeaves),
+	})
+	cg := &countingGetter{NodeGetter: ds}
+	fsys := mustReadFS(t, dirnode, cg)
+
+	open := func(name string) *File {
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f.(*File)
+	}
+	a, b, pb, diff := open("a/file"), open("b/file"), open("pbleaves"), open("diff")
+
+	before := cg.count()
+	same, err := SameContent(a, b)
+	if err != nil {
+		t.Fatalf("failed to compare: %v", err)
+	}
+	if !same {
+		t.Errorf("got different content for files with the same cid")
+	}
+	if n := cg.count() - before; n != 0 {
+		t.Errorf("got %d node requests comparing files with the same cid, wanted 0", n)
+	}
+
+	if a.Cid().Equals(pb.Cid()) {
+		t.Fatalf("files with different leaves unexpectedly have the same cid")
+	}
+	same, err = SameContent(a, pb)
+	if err != nil {
+		t.Fatalf("failed to compare: %v", err)
+	}
+	if !same {
+		t.Errorf("got different content for files with the same content and different cids")
+	}
+
+	same, err = SameContent(a, diff)
+	if err != nil {
+		t.Fatalf("failed to compare: %v", err)
+	}
+	if same {
+		t.Errorf("got same content for files with different content")
+	}
+}
+
+func TestUniqueBlocks(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file":   []byte("shared content"),
+		"b/file":   []byte("shared content"),
+		"b/other":  []byte("other content"),
+		"c/d/file": []byte("shared content"),
+	})
+	if err := ds.Add(context.Background(), fsys.root); err != nil {
+		t.Fatalf("failed to add root node: %v", err)
+	}
+
+	// Count the distinct blocks independently, along with the number of times blocks are reached
+	var wantCount, reached int
+	var wantBytes uint64
+	seen := cid.NewSet()
+	err := merkledag.Walk(context.Background(), merkledag.GetLinksDirect(ds), fsys.root.Cid(), func(c cid.Cid) bool {
+		reached++
+		if !seen.Visit(c) {
+			return false
+		}
+		node, err := ds.Get(context.Background(), c)
+		if err != nil {
+			t.Fatalf("failed to get node: %v", err)
+		}
+		wantCount++
+		wantBytes += uint64(len(node.RawData()))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("failed to walk dag: %v", err)
+	}
+
+	count, size, err := fsys.UniqueBlocks(".")
+	if err != nil {
+		t.Fatalf("failed to count unique blocks: %v", err)
+	}
+	if count != wantCount {
+		t.Errorf("got count %d, wanted %d", count, wantCount)
+	}
+	if size != wantBytes {
+		t.Errorf("got bytes %d, wanted %d", size, wantBytes)
+	}
+
+	// The shared file is stored once but reachable at several paths
+	if count >= reached {
+		t.Errorf("got count %d, wanted fewer than the %d times blocks are reached", count, reached)
+	}
+
+	_, _, err = fsys.UniqueBlocks("/a")
+	var pe *fs.PathError
+	if !errors.As(err, &pe) || pe.Op != "uniqueblocks" || !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error for invalid path, wanted uniqueblocks path error wrapping %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestOpenStat(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/file": []byte("file content"),
+	})
+
+	for _, name := range []string{"a/file", "a", "."} {
+		f, fi, err := fsys.OpenStat(name)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+
+		sfi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", name, err)
+		}
+		if fi != sfi {
+			t.Errorf("%s: OpenStat returned a different FileInfo to Stat", name)
+		}
+		f.Close()
+	}
+
+	if _, _, err := fsys.OpenStat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %q error, wanted %q", err, fs.ErrNotExist)
+	}
+}
+
+func TestOpenMany(t *testing.T) {
+	files := map[string][]byte{}
+	var paths []string
+	for i := 0; i < 30; i++ {
+		name := fmt.Sprintf("dir%d/file%d", i%3, i)
+		files[name] = []byte(name)
+		paths = append(paths, name)
+	}
+	fsys := buildFS(t, mdtest.Mock(), files)
+
+	paths = append(paths, "missing", "dir1")
+
+	opened, errs := fsys.OpenMany(context.Background(), paths)
+	if len(opened) != len(paths) || len(errs) != len(paths) {
+		t.Fatalf("got %d files and %d errors, wanted %d of each", len(opened), len(errs), len(paths))
+	}
+
+	for i, path := range paths {
+		switch path {
+		case "missing":
+			if !errors.Is(errs[i], fs.ErrNotExist) {
+				t.Errorf("%s: got %q error, wanted %q", path, errs[i], fs.ErrNotExist)
+			}
+			continue
+		case "dir1":
+			if errs[i] != nil {
+				t.Errorf("%s: unexpected error: %v", path, errs[i])
+			} else if _, ok := opened[i].(*Dir); !ok {
+				t.Errorf("%s: got %T, wanted a directory", path, opened[i])
+			}
+			continue
+		}
+
+		if errs[i] != nil {
+			t.Errorf("%s: unexpected error: %v", path, errs[i])
+			continue
+		}
+		data, err := io.ReadAll(opened[i])
+		opened[i].Close()
+		if err != nil {
+			t.Errorf("%s: failed to read: %v", path, err)
+			continue
+		}
+		if string(data) != path {
+			t.Errorf("%s: got data %q, wanted %q", path, data, path)
+		}
+	}
+}
+
+func TestRootCid(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file": []byte("file content"),
+	})
+	if err := ds.Add(context.Background(), fsys.root); err != nil {
+		t.Fatalf("failed to add root node: %v", err)
+	}
+
+	c := fsys.RootCid()
+	if !c.Defined() {
+		t.Fatalf("got undefined root cid")
+	}
+
+	rfsys, err := ReadFSMulti(c, ds)
+	if err != nil {
+		t.Fatalf("failed to read fs from root cid: %v", err)
+	}
+	if got := rfsys.RootCid(); !got.Equals(c) {
+		t.Errorf("got root cid %s after round trip, wanted %s", got, c)
+	}
+	data, err := fs.ReadFile(rfsys, "a/file")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "file content" {
+		t.Errorf("got data %q, wanted %q", data, "file content")
+	}
+
+	sub, err := fsys.Sub("a")
+	if err != nil {
+		t.Fatalf("failed to create sub fs: %v", err)
+	}
+	fi, err := fsys.Stat("a")
+	if err != nil {
+		t.Fatalf("failed to stat a: %v", err)
+	}
+	if got, want := sub.(*FS).RootCid(), fi.(*FileInfo).Cid(); !got.Equals(want) {
+		t.Errorf("got sub fs root cid %s, wanted %s", got, want)
+	}
+
+	var empty FS
+	if got := empty.RootCid(); got.Defined() {
+		t.Errorf("got root cid %s for fs without a root, wanted cid.Undef", got)
+	}
+}
+
+func TestETag(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/file": []byte("file content"),
+	})
+
+	etag, err := fsys.ETag()
+	if err != nil {
+		t.Fatalf("failed to get etag: %v", err)
+	}
+	if want := `"` + fsys.root.Cid().String() + `"`; etag != want {
+		t.Errorf("got etag %s, wanted %s", etag, want)
+	}
+
+	sub, err := fsys.Sub("a")
+	if err != nil {
+		t.Fatalf("failed to get sub fs: %v", err)
+	}
+	subtag, err := sub.(*FS).ETag()
+	if err != nil {
+		t.Fatalf("failed to get etag: %v", err)
+	}
+	if subtag == etag {
+		t.Errorf("got same etag %s for sub fs", subtag)
+	}
+
+	if _, err := (&FS{}).ETag(); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error for fs without a root, wanted %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestRandomTreeRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name    string
+		depth   int // levels of subdirectories
+		subdirs int // subdirectories in each directory
+		files   int // files in each directory
+		shard   bool
+	}{
+		{name: "deep", depth: 12, subdirs: 1, files: 2},
+		{name: "wide", depth: 1, subdirs: 3, files: 150},
+		{name: "bushy", depth: 3, subdirs: 3, files: 4},
+		{name: "sharded", depth: 2, subdirs: 2, files: 40, shard: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(len(tc.name))))
+			files := map[string][]byte{}
+			var addDir func(dir string, depth int)
+			addDir = func(dir string, depth int) {
+				for i := 0; i < tc.files; i++ {
+					content := make([]byte, 1+rng.Intn(3000))
+					rng.Read(content)
+					files[path.Join(dir, fmt.Sprintf("file%d", i))] = content
+				}
+				if depth == 0 {
+					return
+				}
+				for i := 0; i < tc.subdirs; i++ {
+					addDir(path.Join(dir, fmt.Sprintf("dir%d", i)), depth-1)
+				}
+			}
+			addDir("", tc.depth)
+
+			if tc.shard {
+				defer func(size int) { uio.HAMTShardingSize = size }(uio.HAMTShardingSize)
+				uio.HAMTShardingSize = 1
+			}
+			fsys := buildFS(t, mdtest.Mock(), files)
+			if tc.shard && !isShardNode(fsys.root) {
+				t.Fatalf("root directory is not sharded")
+			}
+
+			for name, want := range files {
+				f, err := fsys.Open(name)
+				if err != nil {
+					t.Fatalf("failed to open %s: %v", name, err)
+				}
+
+				fi, err := f.Stat()
+				if err != nil {
+					t.Fatalf("failed to stat %s: %v", name, err)
+				}
+				if fi.Size() != int64(len(want)) {
+					t.Errorf("%s: got size %d, wanted %d", name, fi.Size(), len(want))
+				}
+				if fi.Name() != path.Base(name) {
+					t.Errorf("%s: got name %q, wanted %q", name, fi.Name(), path.Base(name))
+				}
+
+				got, err := io.ReadAll(f)
+				f.Close()
+				if err != nil {
+					t.Fatalf("failed to read %s: %v", name, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s: content mismatch", name)
+				}
+			}
+
+			if err := fstest.TestFS(fsys, "file0"); err != nil {
+				t.Errorf("fstest: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileNodeRelink(t *testing.T) {
+	ds := mdtest.Mock()
+	content := bytes.Repeat([]byte("relinked "), 300)
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file": content,
+	})
+
+	f, err := fsys.Open("a/file")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	node := f.(*File).Node()
+	if !node.Cid().Equals(f.(*File).Cid()) {
+		t.Fatalf("got node with cid %s, wanted %s", node.Cid(), f.(*File).Cid())
+	}
+
+	// Link the file into a new tree
+	other := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{"copy": node})
+
+	data, err := fs.ReadFile(other, "copy")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %d bytes of data not matching content", len(data))
+	}
+}
+
+func TestReadDirVisible(t *testing.T) {
+	ds := mdtest.Mock()
+	dirnode := buildRootNode(t, ds, map[string][]byte{
+		"a/visible": []byte("visible"),
+		"a/.hidden": []byte("hidden"),
+		"a/.git/x":  []byte("x"),
+		"a/b.txt":   []byte("b"),
+	}, nil)
+	cg := &countingGetter{NodeGetter: ds}
+	fsys := mustReadFS(t, dirnode, cg)
+
+	before := cg.count()
+	entries, err := fsys.ReadDirVisible("a")
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	requests := cg.count() - before
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if diff := cmp.Diff([]string{"b.txt", "visible"}, names); diff != "" {
+		t.Errorf("ReadDirVisible() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Only the directory is loaded, entries are loaded when their info is requested
+	if requests != 1 {
+		t.Errorf("got %d node requests, wanted 1", requests)
+	}
+	before = cg.count()
+	for _, e := range entries {
+		if _, err := e.Info(); err != nil {
+			t.Fatalf("failed to get info for %s: %v", e.Name(), err)
+		}
+	}
+	if requests := cg.count() - before; requests != 2 {
+		t.Errorf("got %d node requests for entry info, wanted 2", requests)
+	}
+
+	// Hidden entries can still be opened
+	if _, err := fs.ReadFile(fsys, "a/.hidden"); err != nil {
+		t.Errorf("failed to read hidden file: %v", err)
+	}
+}
+
+func TestWriteTarGz(t *testing.T) {
+	files := map[string][]byte{
+		"hello.txt":   []byte("hello"),
+		"a/b/file1":   []byte("file1"),
+		"a/large.bin": bytes.Repeat([]byte("0123456789"), 200),
+		"a/c/empty":   nil, // empty dir
+	}
+	fsys := buildFS(t, mdtest.Mock(), files)
+
+	var buf bytes.Buffer
+	if err := fsys.WriteTarGz(context.Background(), &buf, gzip.BestSpeed); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to read gzip stream: %v", err)
+	}
+	tr := tar.NewReader(zr)
+
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar entry: %v", err)
+		}
+		names = append(names, hdr.Name)
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", hdr.Name, err)
+		}
+		if !bytes.Equal(data, files[hdr.Name]) {
+			t.Errorf("%s: got %d bytes of content, wanted %d", hdr.Name, len(data), len(files[hdr.Name]))
+		}
+	}
+
+	want := []string{"a/", "a/b/", "a/b/file1", "a/c/", "a/c/empty/", "a/large.bin", "hello.txt"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("archive entries mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := fsys.WriteTarGz(context.Background(), io.Discard, 42); err == nil {
+		t.Errorf("got no error for invalid compression level")
+	}
+}
+
+func TestWriteTar(t *testing.T) {
+	files := map[string][]byte{
+		"hello.txt":       []byte("hello"),
+		"a/b/file1":       []byte("file1"),
+		"a/large.bin":     bytes.Repeat([]byte("0123456789"), 200),
+		"a/c/empty":       nil, // empty dir
+		"other/file2.txt": []byte("file2"),
+	}
+	fsys := buildFS(t, mdtest.Mock(), files)
+
+	readTar := func(t *testing.T, root string) (map[string][]byte, map[string]int64) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := fsys.WriteTar(context.Background(), &buf, root); err != nil {
+			t.Fatalf("failed to write archive: %v", err)
+		}
+
+		contents := map[string][]byte{}
+		modes := map[string]int64{}
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read tar entry: %v", err)
+			}
+			modes[hdr.Name] = hdr.Mode
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				contents[strings.TrimSuffix(hdr.Name, "/")] = nil
+			case tar.TypeReg:
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("failed to read %s: %v", hdr.Name, err)
+				}
+				contents[hdr.Name] = data
+			default:
+				t.Errorf("%s: unexpected type flag %v", hdr.Name, hdr.Typeflag)
+			}
+		}
+		return contents, modes
+	}
+
+	t.Run("root", func(t *testing.T) {
+		got, modes := readTar(t, ".")
+		want := map[string][]byte{
+			"a":     nil,
+			"a/b":   nil,
+			"a/c":   nil,
+			"other": nil,
+		}
+		for name, content := range files {
+			want[name] = content
+		}
+		if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("archive contents mismatch (-want +got):\n%s", diff)
+		}
+		if modes["a/"] != 0o755 || modes["hello.txt"] != 0o644 {
+			t.Errorf("got modes %o and %o, wanted 755 and 644", modes["a/"], modes["hello.txt"])
+		}
+	})
+
+	t.Run("subtree", func(t *testing.T) {
+		got, _ := readTar(t, "a")
+		want := map[string][]byte{
+			"b":         nil,
+			"b/file1":   files["a/b/file1"],
+			"c":         nil,
+			"c/empty":   nil,
+			"large.bin": files["a/large.bin"],
+		}
+		if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("archive contents mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		err := fsys.WriteTar(context.Background(), io.Discard, "hello.txt")
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, fs.ErrInvalid)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		err := fsys.WriteTar(context.Background(), io.Discard, "missing")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		err := fsys.WriteTar(context.Background(), io.Discard, "/a")
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, fs.ErrInvalid)
+		}
+	})
+}
+
+func TestWriteZip(t *testing.T) {
+	files := map[string][]byte{
+		"hello.txt":   []byte("hello"),
+		"a/b/file1":   []byte("file1"),
+		"a/large.bin": bytes.Repeat([]byte("0123456789"), 20000),
+		"a/c/empty":   nil, // empty dir
+	}
+	fsys := buildFS(t, mdtest.Mock(), files)
+
+	readZip := func(t *testing.T, root string) ([]string, map[string][]byte) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := fsys.WriteZip(context.Background(), &buf, root); err != nil {
+			t.Fatalf("failed to write archive: %v", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+
+		var names []string
+		contents := map[string][]byte{}
+		for _, zf := range zr.File {
+			names = append(names, zf.Name)
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				t.Fatalf("failed to open %s: %v", zf.Name, err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", zf.Name, err)
+			}
+			contents[zf.Name] = data
+		}
+		return names, contents
+	}
+
+	t.Run("root", func(t *testing.T) {
+		names, contents := readZip(t, ".")
+		wantNames := []string{"a/", "a/b/", "a/b/file1", "a/c/", "a/c/empty/", "a/large.bin", "hello.txt"}
+		if diff := cmp.Diff(wantNames, names); diff != "" {
+			t.Errorf("archive entries mismatch (-want +got):\n%s", diff)
+		}
+		want := map[string][]byte{}
+		for name, content := range files {
+			if content != nil {
+				want[name] = content
+			}
+		}
+		if diff := cmp.Diff(want, contents); diff != "" {
+			t.Errorf("archive contents mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("subtree", func(t *testing.T) {
+		names, _ := readZip(t, "a/b")
+		if diff := cmp.Diff([]string{"file1"}, names); diff != "" {
+			t.Errorf("archive entries mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		err := fsys.WriteZip(context.Background(), io.Discard, "hello.txt")
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, fs.ErrInvalid)
+		}
+	})
+}
+
+func TestStatDoesNotReadContent(t *testing.T) {
+	ds := mdtest.Mock()
+	content := bytes.Repeat([]byte("0123456789"), 300)
+	dirnode := buildRootNode(t, ds, map[string][]byte{
+		"multi": content,
+	}, map[string]ipld.Node{
+		"inline": merkledag.NodeWithData(ufs.FilePBData([]byte("inline content"), uint64(len("inline content")))),
+	})
+	cg := &countingGetter{NodeGetter: ds}
+	fsys := mustReadFS(t, dirnode, cg)
+
+	for name, size := range map[string]int{"multi": len(content), "inline": len("inline content")} {
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+
+		before := cg.count()
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", name, err)
+		}
+		if fi.Size() != int64(size) {
+			t.Errorf("%s: got size %d, wanted %d", name, fi.Size(), size)
+		}
+		if n := cg.count() - before; n != 0 {
+			t.Errorf("%s: got %d node requests for stat, wanted 0", name, n)
+		}
+		if f.(*File).dr != nil {
+			t.Errorf("%s: content reader was created by stat", name)
+		}
+		f.Close()
+	}
+}
+
+func TestFileModeAndModTime(t *testing.T) {
+	ds := mdtest.Mock()
+	mtime := time.Unix(1600000000, 500)
+
+	fsn := ufs.NewFSNode(ufs.TFile)
+	fsn.SetData([]byte("script"))
+	fsn.SetFileMode(0o4755)
+	fsn.SetModTime(mtime)
+	fdata, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatalf("failed to get file data: %v", err)
+	}
+	script := merkledag.NodeWithData(fdata)
+
+	dsn := ufs.NewFSNode(ufs.TDirectory)
+	dsn.SetFileMode(0o700)
+	dsn.SetModTime(mtime)
+	ddata, err := dsn.GetBytes()
+	if err != nil {
+		t.Fatalf("failed to get directory data: %v", err)
+	}
+	private := merkledag.NodeWithData(ddata)
+
+	fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{
+		"script":  script,
+		"private": private,
+		"plain":   utest.GetNode(t, ds, []byte("plain"), utest.UseCidV1),
+	})
+
+	testCases := []struct {
+		name    string
+		mode    fs.FileMode
+		modtime time.Time
+	}{
+		{name: "script", mode: fs.ModeSetuid | 0o755, modtime: mtime},
+		{name: "private", mode: fs.ModeDir | 0o700, modtime: mtime},
+		{name: "plain", mode: 0o644},
+	}
+	for _, tc := range testCases {
+		f, err := fsys.Open(tc.name)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", tc.name, err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", tc.name, err)
+		}
+		f.Close()
+
+		if fi.Mode() != tc.mode {
+			t.Errorf("%s: got mode %v, wanted %v", tc.name, fi.Mode(), tc.mode)
+		}
+		if !fi.ModTime().Equal(tc.modtime) {
+			t.Errorf("%s: got modtime %v, wanted %v", tc.name, fi.ModTime(), tc.modtime)
+		}
+	}
+}
+
+func TestFSStat(t *testing.T) {
+	ds := mdtest.Mock()
+	content := bytes.Repeat([]byte("0123456789"), 300)
+	cg := &countingGetter{NodeGetter: ds}
+	fsys := mustReadFS(t, buildFS(t, ds, map[string][]byte{
+		"a/multi": content,
+		"a/empty": nil, // empty dir
+	}).root, cg)
+
+	before := cg.count()
+	fi, err := fsys.Stat("a/multi")
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if fi.Name() != "multi" || fi.Size() != int64(len(content)) || !fi.Mode().IsRegular() {
+		t.Errorf("got name %q, size %d and mode %v, wanted %q, %d and a regular file", fi.Name(), fi.Size(), fi.Mode(), "multi", len(content))
+	}
+	// Only the directory "a" and the root node of the file are loaded
+	if n := cg.count() - before; n != 2 {
+		t.Errorf("got %d node requests for stat, wanted 2", n)
+	}
+
+	fi, err = fsys.Stat("a/empty")
+	if err != nil {
+		t.Fatalf("failed to stat dir: %v", err)
+	}
+	if fi.Name() != "empty" || !fi.IsDir() || fi.Mode().Type() != fs.ModeDir {
+		t.Errorf("got name %q and mode %v, wanted %q and %v", fi.Name(), fi.Mode(), "empty", fs.ModeDir)
+	}
+
+	if _, err := fsys.Stat("a/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, wanted %v", err, fs.ErrNotExist)
+	}
+	if _, err := fsys.Stat("a/"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v, wanted %v", err, fs.ErrInvalid)
+	}
+}
+
+func TestFSIsDir(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/file":    []byte("file content"),
+		"a/b/empty": nil, // empty dir
+	})
+
+	testCases := []struct {
+		path string
+		want bool
+	}{
+		{".", true},
+		{"a", true},
+		{"a/b/empty", true},
+		{"a/file", false},
+	}
+	for _, tc := range testCases {
+		got, err := fsys.IsDir(tc.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.path, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got IsDir() %v, wanted %v", tc.path, got, tc.want)
+		}
+	}
+
+	if _, err := fsys.IsDir("a/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %q error, wanted %q", err, fs.ErrNotExist)
+	}
+}
+
+func TestNonDefaultHashes(t *testing.T) {
+	content := bytes.Repeat([]byte("hashed content "), 100)
+
+	for _, mhType := range []uint64{mh.BLAKE3, mh.SHA3_256, mh.BLAKE2B_MIN + 31} {
+		name := mh.Codes[mhType]
+		t.Run(name, func(t *testing.T) {
+			ds := mdtest.Mock()
+
+			opts := utest.UseCidV1
+			opts.Prefix.MhType = mhType
+			opts.Prefix.MhLength = -1
+
+			filenode := utest.GetNode(t, ds, content, opts)
+			if got := filenode.Cid().Prefix().MhType; got != mhType {
+				t.Fatalf("got file with hash %s, wanted %s", mh.Codes[got], name)
+			}
+
+			dir := uio.NewDirectory(ds)
+			dir.SetCidBuilder(opts.Prefix)
+			if err := dir.AddChild(context.Background(), "file", filenode); err != nil {
+				t.Fatalf("failed to add child: %v", err)
+			}
+			dirnode, err := dir.GetNode()
+			if err != nil {
+				t.Fatalf("failed to get root directory node: %v", err)
+			}
+			fsys := mustReadFS(t, dirnode, ds)
+			vfsys, err := fsys.WithVerifyBlocks()
+			if err != nil {
+				t.Fatalf("failed to create verifying fs: %v", err)
+			}
+
+			for _, fsys := range []*FS{fsys, vfsys} {
+				data, err := fs.ReadFile(fsys, "file")
+				if err != nil {
+					t.Fatalf("failed to read file: %v", err)
+				}
+				if !bytes.Equal(data, content) {
+					t.Errorf("got %d bytes of data not matching content", len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		ds := mdtest.Mock()
+		fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{
+			"a":    ufs.EmptyDirNode(),
+			"file": utest.GetNode(t, ds, []byte("file content"), utest.UseProtoBufLeaves),
+		})
+		if diff := cmp.Diff(Capabilities{}, fsys.Capabilities()); diff != "" {
+			t.Errorf("capabilities mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("sharded", func(t *testing.T) {
+		fsys := shardedFS(t, []string{"a", "b", "c"})
+		if !fsys.Capabilities().HAMTSharding {
+			t.Errorf("got no HAMT sharding, wanted it to be reported")
+		}
+	})
+
+	t.Run("features", func(t *testing.T) {
+		ds := mdtest.Mock()
+
+		symdata, err := ufs.SymlinkData("target")
+		if err != nil {
+			t.Fatalf("failed to create symlink data: %v", err)
+		}
+
+		fsn := ufs.NewFSNode(ufs.TFile)
+		fsn.SetData([]byte("file content"))
+		fsn.SetFileMode(0o600)
+		fsn.SetModTime(time.Unix(1600000000, 0))
+		fdata, err := fsn.GetBytes()
+		if err != nil {
+			t.Fatalf("failed to get file data: %v", err)
+		}
+
+		fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{
+			"link": merkledag.NodeWithData(symdata),
+			"file": merkledag.NodeWithData(fdata),
+			"raw":  merkledag.NewRawNode([]byte("raw content")),
+		})
+
+		want := Capabilities{
+			Symlinks:  true,
+			RawLeaves: true,
+			Mode:      true,
+			ModTime:   true,
+		}
+		if diff := cmp.Diff(want, fsys.Capabilities()); diff != "" {
+			t.Errorf("capabilities mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		ds := mdtest.Mock()
+		files := make(map[string][]byte, 500)
+		for i := 0; i < 500; i++ {
+			files[fmt.Sprintf("file%03d", i)] = []byte(fmt.Sprintf("content %d", i))
+		}
+		cg := &countingGetter{NodeGetter: ds}
+		fsys := mustReadFS(t, buildRootNode(t, ds, files, nil), cg)
+
+		fsys.Capabilities()
+		if got := cg.count(); got > capabilitySampleLinks {
+			t.Errorf("got %d node requests, wanted at most %d", got, capabilitySampleLinks)
+		}
+
+		sharded := shardedFS(t, []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"})
+		scg := &countingGetter{NodeGetter: sharded.getter}
+		sharded = mustReadFS(t, sharded.root, scg)
+		if !sharded.Capabilities().HAMTSharding {
+			t.Errorf("got no HAMT sharding, wanted it to be reported")
+		}
+		if got := scg.count(); got != 0 {
+			t.Errorf("got %d node requests for a sharded root, wanted none", got)
+		}
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		ds := mdtest.Mock()
+
+		wrap := func(t *testing.T, node ipld.Node) ipld.Node {
+			t.Helper()
+			mdata, err := ufs.BytesForMetadata(&ufs.Metadata{MimeType: "text/plain"})
+			if err != nil {
+				t.Fatalf("failed to create metadata: %v", err)
+			}
+			mdnode := merkledag.NodeWithData(mdata)
+			if err := mdnode.AddNodeLink("file", node); err != nil {
+				t.Fatalf("failed to link wrapped node: %v", err)
+			}
+			if err := ds.Add(context.Background(), node); err != nil {
+				t.Fatalf("failed to add wrapped node: %v", err)
+			}
+			return mdnode
+		}
+
+		plain := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{
+			"file": wrap(t, utest.GetNode(t, ds, []byte("file content"), utest.UseProtoBufLeaves)),
+		})
+		if diff := cmp.Diff(Capabilities{}, plain.Capabilities()); diff != "" {
+			t.Errorf("plain capabilities mismatch (-want +got):\n%s", diff)
+		}
+
+		fsn := ufs.NewFSNode(ufs.TFile)
+		fsn.SetData([]byte("file content"))
+		fsn.SetFileMode(0o600)
+		fdata, err := fsn.GetBytes()
+		if err != nil {
+			t.Fatalf("failed to get file data: %v", err)
+		}
+		moded := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{
+			"file": wrap(t, merkledag.NodeWithData(fdata)),
+			"raw":  wrap(t, merkledag.NewRawNode([]byte("raw content"))),
+		})
+		if diff := cmp.Diff(Capabilities{Mode: true, RawLeaves: true}, moded.Capabilities()); diff != "" {
+			t.Errorf("wrapped capabilities mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestWithContext(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/b/file": []byte("file content"),
+	})
+
+	cfsys := fsys.WithMaxPathDepth(2).WithContext(context.Background())
+
+	if _, err := fs.ReadFile(cfsys, "a/b/file"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v, wanted max path depth to be retained and the open to fail with %v", err, fs.ErrInvalid)
+	}
+
+	sub, err := fs.Sub(cfsys, "a")
+	if err != nil {
+		t.Fatalf("failed to create sub fs: %v", err)
+	}
+	data, err := fs.ReadFile(sub, "b/file")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "file content" {
+		t.Errorf("got data %q, wanted %q", data, "file content")
+	}
+
+	entries, err := fs.ReadDir(sub, "b")
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "file" {
+		t.Errorf("got entries %v, wanted a single entry named file", entries)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	names := make([]string, 2000)
+	for i := range names {
+		names[i] = fmt.Sprintf("file%04d", i)
+	}
+	sharded := shardedFS(t, names)
+	deep := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/b/c/d/e/file": []byte("file content"),
+	})
+
+	testCases := []struct {
+		name string
+		fsys *FS
+		op   func(fsys *FS) error
+	}{
+		{
+			name: "readdir",
+			fsys: sharded,
+			op: func(fsys *FS) error {
+				_, err := fsys.ReadDir(".")
+				return err
+			},
+		},
+		{
+			name: "dir readdir",
+			fsys: sharded,
+			op: func(fsys *FS) error {
+				f, err := fsys.Open(".")
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				_, err = f.(fs.ReadDirFile).ReadDir(-1)
+				return err
+			},
+		},
+		{
+			name: "readdiriter",
+			fsys: sharded,
+			op: func(fsys *FS) error {
+				iter, err := fsys.ReadDirIter(".")
+				if err != nil {
+					return err
+				}
+				iter(func(de fs.DirEntry, iterErr error) bool {
+					err = iterErr
+					return iterErr == nil
+				})
+				return err
+			},
+		},
+		{
+			name: "walk",
+			fsys: sharded,
+			op: func(fsys *FS) error {
+				return fsys.Walk(".", func(path string, d fs.DirEntry, err error) error { return err })
+			},
+		},
+		{
+			name: "open",
+			fsys: deep,
+			op: func(fsys *FS) error {
+				_, err := fsys.Open("a/b/c/d/e/file")
+				return err
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cg := &cancellingGetter{NodeGetter: inMemoryGetter(t, tc.fsys), after: 2, cancel: cancel}
+			fsys := mustReadFS(t, tc.fsys.root, cg)
+
+			err := tc.op(fsys.WithContext(ctx).(*FS))
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, wanted %v", err, context.Canceled)
+			}
+			if cg.extra > 1 {
+				t.Errorf("got %d node requests after cancellation, wanted at most 1", cg.extra)
+			}
+		})
+	}
+}
+
+// cancellingGetter calls cancel once it has returned after nodes, counting the requests made
+// after that. It ignores the context passed to Get so that cancellation must be noticed by the FS.
+type cancellingGetter struct {
+	ipld.NodeGetter
+	after  int
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	n      int
+	extra  int
+}
+
+func (g *cancellingGetter) Get(_ context.Context, c cid.Cid) (ipld.Node, error) {
+	g.mu.Lock()
+	g.n++
+	if g.n == g.after {
+		g.cancel()
+	} else if g.n > g.after {
+		g.extra++
+	}
+	g.mu.Unlock()
+	return g.NodeGetter.Get(context.Background(), c)
+}
+
+func TestClose(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"a/file": []byte("file content"),
+	})
+
+	f, err := fsys.Open("a/file")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	derived := fsys.WithContext(context.Background())
+
+	if err := fsys.Close(); err != nil {
+		t.Fatalf("failed to close fs: %v", err)
+	}
+
+	_, err = fsys.Open("a/file")
+	if !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("open: got %q error, wanted %q", err, fs.ErrClosed)
+	}
+
+	_, err = fsys.ReadDir("a")
+	if !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("readdir: got %q error, wanted %q", err, fs.ErrClosed)
+	}
+
+	_, err = fsys.Sub("a")
+	if !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("sub: got %q error, wanted %q", err, fs.ErrClosed)
+	}
+
+	// Filesystems derived before Close remain usable
+	if _, err := fs.ReadFile(derived, "a/file"); err != nil {
+		t.Errorf("failed to read file from derived fs: %v", err)
+	}
+
+	// Filesystems derived after Close are closed too
+	verified, err := fsys.WithVerifyBlocks()
+	if err != nil {
+		t.Fatalf("failed to derive verifying fs: %v", err)
+	}
+	afterClose := map[string]fs.FS{
+		"WithContext":        fsys.WithContext(context.Background()),
+		"WithMaxPathDepth":   fsys.WithMaxPathDepth(10),
+		"WithMaxSymlinkHops": fsys.WithMaxSymlinkHops(10),
+		"WithURLUnescape":    fsys.WithURLUnescape(),
+		"WithVerifyBlocks":   verified,
+	}
+	for name, dfsys := range afterClose {
+		if _, err := fs.ReadFile(dfsys, "a/file"); !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("%s: got %q error, wanted %q", name, err, fs.ErrClosed)
+		}
+	}
+	if fsys.links.Load() != nil {
+		t.Errorf("links cache was not released")
+	}
+
+	// Files opened before Close remain usable
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "file content" {
+		t.Errorf("got data %q, wanted %q", data, "file content")
+	}
+}
+
+func TestFileConcurrentFirstUse(t *testing.T) {
+	content := bytes.Repeat([]byte("concurrent content "), 1000)
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"file": content,
+	})
+
+	f, err := fsys.Open("file")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	c := f.(*File).Cid()
+	f.Close()
+
+	lf, err := fsys.OpenFileCid(c, "file")
+	if err != nil {
+		t.Fatalf("failed to open file by cid: %v", err)
+	}
+	defer lf.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			// Name does not wait for the load so races with it unless the load leaves the name alone
+			name := lf.Name()
+			fi, err := lf.Stat()
+			if err != nil {
+				errs <- fmt.Errorf("stat: %w", err)
+				return
+			}
+			if fi.Size() != int64(len(content)) || lf.Size() != int64(len(content)) {
+				errs <- fmt.Errorf("got size %d, wanted %d", fi.Size(), len(content))
+			}
+			if name != "file" || fi.Name() != "file" {
+				errs <- fmt.Errorf("got name %q, wanted %q", name, "file")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			r, err := lf.RangeReader(0, int64(len(content)))
+			if err != nil {
+				errs <- fmt.Errorf("range reader: %w", err)
+				return
+			}
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err != nil {
+				errs <- fmt.Errorf("read range: %w", err)
+				return
+			}
+			if !bytes.Equal(data, content) {
+				errs <- fmt.Errorf("got %d bytes of range data not matching content", len(data))
+			}
+		}()
+	}
+
+	// A single reader may use the read position alongside the concurrent metadata calls
+	data, err := io.ReadAll(lf)
+	wg.Wait()
+	close(errs)
+
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %d bytes of data not matching content", len(data))
+	}
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	names := make([]string, 200)
+	paths := make([]string, len(names))
+	files := map[string][]byte{}
+	for i := range names {
+		names[i] = fmt.Sprintf("file%03d", i)
+		paths[i] = fmt.Sprintf("dir%d/%s", i%5, names[i])
+		files[paths[i]] = bytes.Repeat([]byte(names[i]), 200)
+	}
+
+	testCases := []struct {
+		name  string
+		fsys  *FS
+		paths []string
+	}{
+		{name: "basic", fsys: buildFS(t, mdtest.Mock(), files), paths: paths},
+		{name: "sharded", fsys: shardedFS(t, names), paths: names},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Serve the nodes from memory without locking so the race detector is not hidden by
+			// synchronization within the getter
+			fsys := mustReadFS(t, tc.fsys.root, inMemoryGetter(t, tc.fsys))
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 16)
+			for g := 0; g < 16; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < len(tc.paths); i++ {
+						p := tc.paths[(i*7+g*13)%len(tc.paths)]
+						if _, err := fsys.ReadFile(p); err != nil {
+							errs <- fmt.Errorf("read %s: %w", p, err)
+							return
+						}
+						if _, err := fsys.Stat(p); err != nil {
+							errs <- fmt.Errorf("stat %s: %w", p, err)
+							return
+						}
+						if _, err := fsys.ReadDir(path.Dir(p)); err != nil {
+							errs <- fmt.Errorf("readdir %s: %w", path.Dir(p), err)
+							return
+						}
+						if _, err := fsys.Glob(path.Join(path.Dir(p), "*")); err != nil {
+							errs <- fmt.Errorf("glob %s: %w", path.Dir(p), err)
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// inMemoryGetter returns a getter holding every block reachable from the root of fsys.
+func inMemoryGetter(t testing.TB, fsys *FS) blockGetter {
+	t.Helper()
+	g := blockGetter{}
+	stack := []ipld.Node{fsys.root}
+	for len(stack) > 0 {
+		nd := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := g[nd.Cid()]; ok {
+			continue
+		}
+		b, err := blocks.NewBlockWithCid(nd.RawData(), nd.Cid())
+		if err != nil {
+			t.Fatalf("failed to create block: %v", err)
+		}
+		g[nd.Cid()] = b
+		for _, l := range nd.Links() {
+			child, err := l.GetNode(context.Background(), fsys.getter)
+			if err != nil {
+				t.Fatalf("failed to get node: %v", err)
+			}
+			stack = append(stack, child)
+		}
+	}
+	return g
+}
+
+func TestFileCid(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"small": []byte("small content"),
+		"large": bytes.Repeat([]byte("large content "), 100000),
+	})
+
+	links, err := fsys.RootLinks()
+	if err != nil {
+		t.Fatalf("failed to get root links: %v", err)
+	}
+
+	for _, l := range links {
+		t.Run(l.Name, func(t *testing.T) {
+			f, err := fsys.Open(l.Name)
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			defer f.Close()
+
+			if got := f.(*File).Cid(); !got.Equals(l.Cid) {
+				t.Errorf("got file cid %s, wanted %s", got, l.Cid)
+			}
+
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatalf("failed to stat file: %v", err)
+			}
+			if got := fi.(*FileInfo).Cid(); !got.Equals(l.Cid) {
+				t.Errorf("got file info cid %s, wanted %s", got, l.Cid)
+			}
+		})
+	}
+
+	var fi FileInfo
+	if got := fi.Cid(); got.Defined() {
+		t.Errorf("got cid %s for file info without a node, wanted cid.Undef", got)
+	}
+}
+
+func TestOpenFileCid(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file": []byte("file content"),
+	})
+
+	f, err := fsys.Open("a/file")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	c := f.(*File).Cid()
+	f.Close()
+
+	cg := &countingGetter{NodeGetter: ds}
+	lazyfs := mustReadFS(t, fsys.root, cg)
+
+	lf, err := lazyfs.OpenFileCid(c, "file")
+	if err != nil {
+		t.Fatalf("failed to open file by cid: %v", err)
+	}
+	defer lf.Close()
+
+	if lf.Cid() != c {
+		t.Errorf("got cid %s, wanted %s", lf.Cid(), c)
+	}
+	if n := cg.count(); n != 0 {
+		t.Errorf("got %d node requests before first read, wanted 0", n)
+	}
+
+	data, err := io.ReadAll(lf)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "file content" {
+		t.Errorf("got data %q, wanted %q", data, "file content")
+	}
+
+	fi, err := lf.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if fi.Size() != int64(len("file content")) {
+		t.Errorf("got size %d, wanted %d", fi.Size(), len("file content"))
+	}
+
+	// A file closed before use is never loaded
+	before := cg.count()
+	unused, err := lazyfs.OpenFileCid(c, "file")
+	if err != nil {
+		t.Fatalf("failed to open file by cid: %v", err)
+	}
+	if err := unused.Close(); err != nil {
+		t.Errorf("failed to close unused file: %v", err)
+	}
+	if _, err := unused.Read(make([]byte, 1)); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("read after close: got %q error, wanted %q", err, fs.ErrClosed)
+	}
+	if n := cg.count(); n != before {
+		t.Errorf("got %d node requests for unused file, wanted 0", n-before)
+	}
+
+	// Errors loading the node are reported on first use
+	fi, err = fs.Stat(fsys, "a")
+	if err != nil {
+		t.Fatalf("failed to stat directory: %v", err)
+	}
+	dir, err := lazyfs.OpenFileCid(fi.(*FileInfo).Cid(), "a")
+	if err != nil {
+		t.Fatalf("failed to open file by cid: %v", err)
+	}
+	if _, err := dir.Stat(); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("stat directory: got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestWithVerifyBlocks(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file":  []byte("file content"),
+		"a/other": []byte("other content"),
+	})
+
+	fileInfo, err := fs.Stat(fsys, "a/file")
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	otherInfo, err := fs.Stat(fsys, "a/other")
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	c := fileInfo.(*FileInfo).Cid()
+
+	// Serve the other file's data under the file's cid
+	otherData := otherInfo.Sys().(ipld.Node).RawData()
+	corrupt, err := merkledag.DecodeProtobufBlock(mustBlock(t, otherData, c))
+	if err != nil {
+		t.Fatalf("failed to decode block: %v", err)
+	}
+	cg := &corruptGetter{NodeGetter: ds, nodes: map[cid.Cid]ipld.Node{c: corrupt}}
+
+	unverified := mustReadFS(t, fsys.root, cg)
+	data, err := fs.ReadFile(unverified, "a/file")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "other content" {
+		t.Fatalf("got data %q, wanted corrupted data to be served when not verifying", data)
+	}
+
+	verified, err := unverified.WithVerifyBlocks()
+	if err != nil {
+		t.Fatalf("failed to create verifying fs: %v", err)
+	}
+
+	_, err = verified.Open("a/file")
+	if !errors.Is(err, ErrBlockVerification) {
+		t.Errorf("got %q error, wanted %q", err, ErrBlockVerification)
+	}
+
+	data, err = fs.ReadFile(verified, "a/other")
+	if err != nil {
+		t.Fatalf("failed to read uncorrupted file: %v", err)
+	}
+	if string(data) != "other content" {
+		t.Errorf("got data %q, wanted %q", data, "other content")
+	}
+}
+
+func mustBlock(t *testing.T, data []byte, c cid.Cid) blocks.Block {
+	t.Helper()
+	b, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		t.Fatalf("failed to create block: %v", err)
+	}
+	return b
+}
+
+// corruptGetter returns replacement nodes for some cids in place of those held by the wrapped NodeGetter.
+func TestGetterErrorPropagates(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file": []byte("file content"),
+		"b":      []byte("file content"),
+	})
+
+	errLoad := errors.New("load failed")
+	efsys := mustReadFS(t, fsys.root, &errGetter{err: errLoad})
+
+	for _, name := range []string{"a", "a/file", "b"} {
+		_, err := efsys.Open(name)
+		if !errors.Is(err, errLoad) {
+			t.Errorf("open %s: got error %v, wanted it to wrap %v", name, err, errLoad)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("open %s: got error %v, wanted it not to be reported as not existing", name, err)
+		}
+	}
+
+	// Entries are listed from the root node and only loaded when their info is requested
+	entries, err := efsys.ReadDir(".")
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	for _, e := range entries {
+		if _, err := e.Info(); !errors.Is(err, errLoad) {
+			t.Errorf("info %s: got error %v, wanted it to wrap %v", e.Name(), err, errLoad)
+		}
+		if e.Type() != fs.ModeIrregular {
+			t.Errorf("type %s: got %v, wanted %v", e.Name(), e.Type(), fs.ModeIrregular)
+		}
+	}
+}
+
+// errGetter fails every request with err.
+type errGetter struct {
+	err error
+}
+
+func (g *errGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	return nil, g.err
+}
+
+func (g *errGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	for range cids {
+		ch <- &ipld.NodeOption{Err: g.err}
+	}
+	close(ch)
+	return ch
+}
+
+type corruptGetter struct {
+	ipld.NodeGetter
+	nodes map[cid.Cid]ipld.Node
+}
+
+func (g *corruptGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if n, ok := g.nodes[c]; ok {
+		return n, nil
+	}
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func TestExternalDataGetter(t *testing.T) {
+	ds := mdtest.Mock()
+	content := bytes.Repeat([]byte("external "), 300)
+	fsys := buildFS(t, ds, map[string][]byte{
+		"a/file": content,
+	})
+
+	if err := ds.Add(context.Background(), fsys.root); err != nil {
+		t.Fatalf("failed to add root node: %v", err)
+	}
+
+	// Copy every block into a single external file, recording the position of each
+	var external bytes.Buffer
+	positions := map[cid.Cid][2]int{}
+	err := merkledag.Walk(context.Background(), merkledag.GetLinksDirect(ds), fsys.root.Cid(), func(c cid.Cid) bool {
+		node, err := ds.Get(context.Background(), c)
+		if err != nil {
+			t.Fatalf("failed to get node: %v", err)
+		}
+		positions[c] = [2]int{external.Len(), len(node.RawData())}
+		external.Write(node.RawData())
+		return true
+	})
+	if err != nil {
+		t.Fatalf("failed to walk dag: %v", err)
+	}
+
+	getter := &externalGetter{
+		r:         bytes.NewReader(external.Bytes()),
+		positions: positions,
+	}
+	efsys := mustReadFS(t, fsys.root, getter)
+
+	data, err := fs.ReadFile(efsys, "a/file")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %d bytes of data not matching content", len(data))
+	}
+}
+
+// externalGetter is an ipld.NodeGetter that reads block data from positions within an external file.
+type externalGetter struct {
+	r         io.ReaderAt
+	positions map[cid.Cid][2]int // offset and length of each block
+}
+
+func (g *externalGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	pos, ok := g.positions[c]
+	if !ok {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	data := make([]byte, pos[1])
+	if _, err := g.r.ReadAt(data, int64(pos[0])); err != nil {
+		return nil, err
+	}
+	b, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type() == cid.Raw {
+		return merkledag.DecodeRawBlock(b)
+	}
+	return merkledag.DecodeProtobufBlock(b)
+}
+
+func (g *externalGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	for _, c := range cids {
+		node, err := g.Get(ctx, c)
+		ch <- &ipld.NodeOption{Node: node, Err: err}
+	}
+	close(ch)
+	return ch
+}
+
+// countingGetter counts the nodes requested from the wrapped NodeGetter.
+type countingGetter struct {
+	ipld.NodeGetter
+	mu sync.Mutex
+	n  int
+}
+
+func (g *countingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	g.mu.Lock()
+	g.n++
+	g.mu.Unlock()
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func (g *countingGetter) count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.n
+}
+
+// requestKey is the context key used to mark the context of a request.
+type requestKey struct{}
+
+// contextCheckingGetter counts the nodes requested with a context that is not marked with
+// requestKey.
+type contextCheckingGetter struct {
+	ipld.NodeGetter
+	mu       sync.Mutex
+	unmarked int
+}
+
+func (g *contextCheckingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if ctx.Value(requestKey{}) == nil {
+		g.mu.Lock()
+		g.unmarked++
+		g.mu.Unlock()
+	}
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func TestFileServerRequestContext(t *testing.T) {
+	content := bytes.Repeat([]byte("request content "), 1000)
+	ds := mdtest.Mock()
+	dirnode := buildRootNode(t, ds, map[string][]byte{
+		"a/file.txt": content,
+	}, nil)
+	g := &contextCheckingGetter{NodeGetter: ds}
+	fsys := mustReadFS(t, dirnode, g)
+
+	req := httptest.NewRequest(http.MethodGet, "/a/file.txt", nil)
+	req = req.WithContext(context.WithValue(req.Context(), requestKey{}, true))
+	rec := httptest.NewRecorder()
+	fsys.FileServer().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, wanted %d", rec.Code, http.StatusOK)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Errorf("got %d bytes of body not matching content", rec.Body.Len())
+	}
+	if g.unmarked != 0 {
+		t.Errorf("got %d node requests without the request context, wanted none", g.unmarked)
+	}
+}
+
+func TestExtractTo(t *testing.T) {
+	files := map[string][]byte{
+		"hello.txt":   []byte("hello"),
+		"a/b/file1":   []byte("file1"),
+		"a/b/file2":   []byte("file2 content"),
+		"a/c/empty":   nil, // empty dir
+		"a/large.bin": bytes.Repeat([]byte("0123456789"), 200),
+	}
+	fsys := buildFS(t, mdtest.Mock(), files)
+
+	dest := filepath.Join(t.TempDir(), "out")
+	if err := fsys.ExtractTo(context.Background(), dest); err != nil {
+		t.Fatalf("failed to extract: %v", err)
+	}
+
+	for name, want := range files {
+		p := filepath.Join(dest, filepath.FromSlash(name))
+		if want == nil {
+			fi, err := os.Stat(p)
+			if err != nil {
+				t.Errorf("failed to stat %s: %v", name, err)
+			} else if !fi.IsDir() {
+				t.Errorf("%s: got mode %v, wanted a directory", name, fi.Mode())
+			}
+			continue
+		}
+
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes of content, wanted %d", name, len(got), len(want))
+		}
+	}
+}
+
+func TestExtractToChainedSymlinks(t *testing.T) {
+	testCases := []struct {
+		name  string
+		links map[string]string
+	}{
+		{
+			// up resolves to the destination so up/.. is its parent
+			name:  "earlier link",
+			links: map[string]string{"up": "a/..", "up2": "up/.."},
+		},
+		{
+			// y is only created after x has been checked
+			name:  "later link",
+			links: map[string]string{"x": "y/..", "y": "a/.."},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := symlinkFS(t, tc.links)
+			dest := filepath.Join(t.TempDir(), "out")
+			err := fsys.ExtractTo(context.Background(), dest)
+			if !errors.Is(err, fs.ErrInvalid) {
+				t.Errorf("got error %v, wanted %v", err, fs.ErrInvalid)
+			}
+		})
+	}
+}
+
+func TestExtractToExistingSymlinks(t *testing.T) {
+	fsys := buildFS(t, mdtest.Mock(), map[string][]byte{
+		"hello.txt": []byte("hello"),
+		"a/file":    []byte("file"),
+	})
+
+	outside := t.TempDir()
+	outsideFile := filepath.Join(outside, "target.txt")
+	if err := os.WriteFile(outsideFile, []byte("outside"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	dest := t.TempDir()
+	if err := os.Symlink(outsideFile, filepath.Join(dest, "hello.txt")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "a")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	if err := fsys.ExtractTo(context.Background(), dest); err != nil {
+		t.Fatalf("failed to extract: %v", err)
+	}
+
+	if data, err := os.ReadFile(outsideFile); err != nil || string(data) != "outside" {
+		t.Errorf("got outside file content %q, %v, wanted it unchanged", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "file")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v statting file in outside directory, wanted %v", err, fs.ErrNotExist)
+	}
+	for _, name := range []string{"hello.txt", "a"} {
+		fi, err := os.Lstat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", name, err)
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			t.Errorf("%s: is still a symbolic link", name)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "hello.txt")); err != nil || string(data) != "hello" {
+		t.Errorf("got content %q, %v, wanted %q", data, err, "hello")
+	}
+}
+
+func TestExtractToReadOnlyDir(t *testing.T) {
+	ds := mdtest.Mock()
+	dsn := ufs.NewFSNode(ufs.TDirectory)
+	dsn.SetFileMode(0o555)
+	ddata, err := dsn.GetBytes()
+	if err != nil {
+		t.Fatalf("failed to get directory data: %v", err)
+	}
+	ro := merkledag.NodeWithData(ddata)
+	if err := ro.AddNodeLink("f", utest.GetNode(t, ds, []byte("read only"), utest.UseCidV1)); err != nil {
+		t.Fatalf("failed to link file: %v", err)
+	}
+	fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{"ro": ro})
+
+	dest := t.TempDir()
+	t.Cleanup(func() { os.Chmod(filepath.Join(dest, "ro"), 0o755) })
+	if err := fsys.ExtractTo(context.Background(), dest); err != nil {
+		t.Fatalf("failed to extract: %v", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dest, "ro"))
+	if err != nil {
+		t.Fatalf("failed to stat directory: %v", err)
+	}
+	if got := fi.Mode().Perm(); got != 0o555 {
+		t.Errorf("got mode %v, wanted %v", got, fs.FileMode(0o555))
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "ro", "f")); err != nil || string(data) != "read only" {
+		t.Errorf("got content %q, %v, wanted %q", data, err, "read only")
+	}
+}
+
+func TestExtractToFileModes(t *testing.T) {
+	ds := mdtest.Mock()
+	moded := func(t *testing.T, content string, mode fs.FileMode) ipld.Node {
+		t.Helper()
+		fsn := ufs.NewFSNode(ufs.TFile)
+		fsn.SetData([]byte(content))
+		fsn.SetFileMode(mode)
+		data, err := fsn.GetBytes()
+		if err != nil {
+			t.Fatalf("failed to get file data: %v", err)
+		}
+		return merkledag.NodeWithData(data)
+	}
+
+	modes := map[string]fs.FileMode{
+		"group":  0o664,
+		"all":    0o666,
+		"exec":   0o777,
+		"secret": 0o600,
+	}
+	nodes := make(map[string]ipld.Node, len(modes))
+	for name, mode := range modes {
+		nodes[name] = moded(t, name+" content", mode)
+	}
+	fsys := buildFSWithNodes(t, ds, map[string][]byte{"plain": []byte("plain content")}, nodes)
+
+	dest := t.TempDir()
+	if err := fsys.ExtractTo(context.Background(), dest); err != nil {
+		t.Fatalf("failed to extract: %v", err)
+	}
+
+	for name, want := range modes {
+		fi, err := os.Stat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", name, err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("%s: got mode %v, wanted %v", name, got, want)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "group")); err != nil || string(data) != "group content" {
+		t.Errorf("got content %q, %v, wanted %q", data, err, "group content")
+	}
+}
+
+func TestExtractToUnsafeName(t *testing.T) {
+	ds := mdtest.Mock()
+	fsys := buildFSWithNodes(t, ds, nil, map[string]ipld.Node{
+		"..": utest.GetNode(t, ds, []byte("escaped"), utest.UseCidV1),
+	})
+
+	dest := filepath.Join(t.TempDir(), "out")
+	err := fsys.ExtractTo(context.Background(), dest)
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got %q error, wanted %q", err, fs.ErrInvalid)
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	ds := mdtest.Mock()
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	fsn := ufs.NewFSNode(ufs.TFile)
+	fsn.SetData([]byte("0123456789abcdefghij"))
+	fsn.SetModTime(mtime)
+	fdata, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatalf("failed to get file data: %v", err)
+	}
+	stamped := merkledag.NodeWithData(fdata)
+
+	large := make([]byte, 4<<20)
+	rand.New(rand.NewSource(7)).Read(large)
+
+	dirnode := buildRootNode(t, ds, map[string][]byte{
+		"sub/large.bin": large,
+	}, map[string]ipld.Node{
+		"stamped.txt": stamped,
+	})
+	cg := &countingGetter{NodeGetter: ds}
+	fsys := mustReadFS(t, dirnode, cg)
+
+	srv := httptest.NewServer(fsys.FileServer())
+	defer srv.Close()
+
+	get := func(t *testing.T, p string, header http.Header) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+p, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get %s: %v", p, err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		return resp, body
+	}
+
+	t.Run("range", func(t *testing.T) {
+		resp, body := get(t, "/stamped.txt", http.Header{"Range": {"bytes=5-10"}})
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("got status %d, wanted %d", resp.StatusCode, http.StatusPartialContent)
+		}
+		if string(body) != "56789a" {
+			t.Errorf("got body %q, wanted %q", body, "56789a")
+		}
+		if got, want := resp.Header.Get("Last-Modified"), mtime.Format(http.TimeFormat); got != want {
+			t.Errorf("got Last-Modified %q, wanted %q", got, want)
+		}
+		if got, want := resp.Header.Get("Etag"), `"`+stamped.Cid().String()+`"`; got != want {
+			t.Errorf("got Etag %q, wanted %q", got, want)
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		resp, _ := get(t, "/stamped.txt", http.Header{"If-None-Match": {`"` + stamped.Cid().String() + `"`}})
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("got status %d, wanted %d", resp.StatusCode, http.StatusNotModified)
+		}
+	})
+
+	t.Run("large range", func(t *testing.T) {
+		before := cg.count()
+		resp, body := get(t, "/sub/large.bin", http.Header{"Range": {"bytes=3000000-3000099"}})
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("got status %d, wanted %d", resp.StatusCode, http.StatusPartialContent)
+		}
+		if !bytes.Equal(body, large[3000000:3000100]) {
+			t.Errorf("got wrong range content")
+		}
+		// the directory, the file root and the leaves read to sniff the content type and serve the range
+		if got := cg.count() - before; got > 5 {
+			t.Errorf("got %d node requests, wanted at most 5", got)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		resp, body := get(t, "/sub/", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, wanted %d", resp.StatusCode, http.StatusOK)
+		}
+		if !bytes.Contains(body, []byte("large.bin")) {
+			t.Errorf("directory listing %q does not contain large.bin", body)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		resp, _ := get(t, "/missing.txt", nil)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("got status %d, wanted %d", resp.StatusCode, http.StatusNotFound)
+		}
+	})
 }
 
-func TestOpenChecksForValidName(t *testing.T) {
+func TestReadFSFromCar(t *testing.T) {
+	ds := mdtest.Mock()
+	firstNode := buildRootNode(t, ds, map[string][]byte{
+		"hello.txt":     []byte("hello1"),
+		"dir/world.txt": []byte("world"),
+	}, nil)
+	secondNode := buildRootNode(t, ds, map[string][]byte{
+		"other.txt": []byte("other"),
+	}, nil)
+	fileNode := utest.GetNode(t, ds, []byte("not a directory"), utest.UseCidV1)
+
+	car := writeCar(t, ds, firstNode, secondNode, fileNode)
+
+	t.Run("first root", func(t *testing.T) {
+		fsys, err := ReadFSFromCar(bytes.NewReader(car))
+		if err != nil {
+			t.Fatalf("ReadFSFromCar: %v", err)
+		}
+		if err := fstest.TestFS(fsys, "hello.txt", "dir/world.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if got := fsys.RootCid(); !got.Equals(firstNode.Cid()) {
+			t.Errorf("got root %s, wanted %s", got, firstNode.Cid())
+		}
+	})
+
+	t.Run("selected root", func(t *testing.T) {
+		fsys, err := ReadFSFromCar(bytes.NewReader(car), WithCarRoot(secondNode.Cid()))
+		if err != nil {
+			t.Fatalf("ReadFSFromCar: %v", err)
+		}
+		if err := fstest.TestFS(fsys, "other.txt"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("carv2", func(t *testing.T) {
+		fsys, err := ReadFSFromCar(bytes.NewReader(wrapCarV2(car)))
+		if err != nil {
+			t.Fatalf("ReadFSFromCar: %v", err)
+		}
+		data, err := fsys.ReadFile("dir/world.txt")
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if string(data) != "world" {
+			t.Errorf("got %q, wanted %q", data, "world")
+		}
+	})
+
+	t.Run("root not a directory", func(t *testing.T) {
+		_, err := ReadFSFromCar(bytes.NewReader(car), WithCarRoot(fileNode.Cid()))
+		if err == nil {
+			t.Fatal("got no error, wanted one")
+		}
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, fs.ErrInvalid)
+		}
+	})
+
+	t.Run("root not in car", func(t *testing.T) {
+		missing := utest.GetNode(t, mdtest.Mock(), []byte("missing"), utest.UseCidV1)
+		_, err := ReadFSFromCar(bytes.NewReader(car), WithCarRoot(missing.Cid()))
+		if !errors.Is(err, ipld.ErrNotFound{}) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, ipld.ErrNotFound{})
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := ReadFSFromCar(bytes.NewReader(car[:len(car)-3]))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("got error %v, wanted one wrapping %v", err, io.ErrUnexpectedEOF)
+		}
+	})
+}
+
+// TestReadFSFromCarFixtures reads CAR files written by boxo's reference CAR implementation rather
+// than by writeCar, so that a misreading of the CAR format shared by the reader and the test writer
+// is caught. unixfs-v1.car was written by car.WriteCar and unixfs-v2.car by wrapping a CARv1 with
+// two roots using carv2.WrapV1, which adds an index after the data payload. Both hold a directory
+// of hello.txt and sub/large.bin, a 3000 byte file chunked into 1024 byte blocks holding i%251 at
+// offset i, and the second root of unixfs-v2.car is a directory holding second.txt.
+func TestReadFSFromCarFixtures(t *testing.T) {
+	first := cid.MustParse("QmW7fdsqftTGCSxNQUApC447BDKpfR76FFHBqVPPGRiFfE")
+	second := cid.MustParse("QmfP8j2cxwBcTSZDXi77inJBmPVF5xq3iUJqKSLjQhb3ru")
+
+	large := make([]byte, 3000)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
 	testCases := []struct {
 		name  string
-		valid bool
+		file  string
+		opts  []CarOption
+		root  cid.Cid
+		files map[string][]byte
 	}{
-		{".", true},
-		{"x", true},
-		{"x/y", true},
-
-		{"", false},
-		{"..", false},
-		{"/", false},
-		{"x/", false},
-		{"/x", false},
-		{"x/y/", false},
-		{"/x/y", false},
-		{"./", false},
-		{"./x", false},
-		{"x/.", false},
-		{"x/./y", false},
-		{"../", false},
-		{"../x", false},
-		{"x/..", false},
-		{"x/../y", false},
-		{"x//y", false},
-		{`x\`, true},
-		{`x\y`, true},
-		{`x:y`, true},
-		{`\x`, true},
+		{
+			name: "carv1",
+			file: "unixfs-v1.car",
+			root: first,
+			files: map[string][]byte{
+				"hello.txt":     []byte("hello from a reference car\n"),
+				"sub/large.bin": large,
+			},
+		},
+		{
+			name: "carv2",
+			file: "unixfs-v2.car",
+			root: first,
+			files: map[string][]byte{
+				"hello.txt":     []byte("hello from a reference car\n"),
+				"sub/large.bin": large,
+			},
+		},
+		{
+			name: "carv2 second root",
+			file: "unixfs-v2.car",
+			opts: []CarOption{WithCarRoot(second)},
+			root: second,
+			files: map[string][]byte{
+				"second.txt": []byte("second root\n"),
+			},
+		},
 	}
 
-	ds := mdtest.Mock()
-	fsys := buildFS(t, ds, nil)
-
 	for _, tc := range testCases {
-		_, err := fsys.Open(tc.name)
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatalf("failed to open fixture: %v", err)
+			}
+			defer f.Close()
 
-		if errors.Is(err, fs.ErrInvalid) {
-			if tc.valid {
-				t.Errorf("%q invalid, expected name to be valid: %v", tc.name, err)
+			fsys, err := ReadFSFromCar(f, tc.opts...)
+			if err != nil {
+				t.Fatalf("ReadFSFromCar: %v", err)
 			}
-		} else {
-			if !tc.valid {
-				t.Errorf("%q valid, expected name to be invalid: %v", tc.name, err)
+			if got := fsys.RootCid(); !got.Equals(tc.root) {
+				t.Errorf("got root %s, wanted %s", got, tc.root)
 			}
-		}
+
+			vfs, err := fsys.WithVerifyBlocks()
+			if err != nil {
+				t.Fatalf("WithVerifyBlocks: %v", err)
+			}
+			for name, want := range tc.files {
+				got, err := vfs.ReadFile(name)
+				if err != nil {
+					t.Errorf("%s: ReadFile: %v", name, err)
+					continue
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s: got %d bytes not matching the fixture content", name, len(got))
+				}
+			}
+		})
 	}
 }
 
-func TestGlob(t *testing.T) {
+func TestReadFSFromCid(t *testing.T) {
 	ds := mdtest.Mock()
-	fsys := buildFS(t, ds, map[string][]byte{
-		"hello.txt":            []byte("hello1"),
-		"test/hello2.txt":      []byte("hello2"),
-		"test/sub/hello4.txt":  []byte("hello4"),
-		"test/sub2/hello5.txt": []byte("hello5"),
-		"test/goodbye.txt":     []byte("goodbye"),
+	dirNode := buildRootNode(t, ds, map[string][]byte{
+		"hello.txt":     []byte("hello"),
+		"dir/world.txt": []byte("world"),
+	}, nil)
+	fileNode := utest.GetNode(t, ds, []byte("not a directory"), utest.UseCidV1)
+
+	t.Run("directory", func(t *testing.T) {
+		fsys, err := ReadFSFromCid(context.Background(), dirNode.Cid(), ds)
+		if err != nil {
+			t.Fatalf("ReadFSFromCid: %v", err)
+		}
+		if err := fstest.TestFS(fsys, "hello.txt", "dir/world.txt"); err != nil {
+			t.Fatal(err)
+		}
 	})
 
-	got, err := fs.Glob(fsys, "*/*.txt")
+	t.Run("file root", func(t *testing.T) {
+		_, err := ReadFSFromCid(context.Background(), fileNode.Cid(), ds)
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got error %v, wanted %v", err, fs.ErrInvalid)
+		}
+		if err == nil || !strings.Contains(err.Error(), fileNode.Cid().String()) {
+			t.Errorf("got error %v, wanted it to name the root %s", err, fileNode.Cid())
+		}
+	})
+
+	t.Run("missing root", func(t *testing.T) {
+		missing := utest.GetNode(t, mdtest.Mock(), []byte("missing"), utest.UseCidV1)
+		_, err := ReadFSFromCid(context.Background(), missing.Cid(), ds)
+		if !ipld.IsNotFound(err) {
+			t.Errorf("got error %v, wanted not found", err)
+		}
+	})
+}
+
+// blockServiceStore adapts a BlockService to the Blockstore interface.
+type blockServiceStore struct {
+	bserv blockservice.BlockService
+}
+
+func (s blockServiceStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return s.bserv.GetBlock(ctx, c)
+}
+
+func TestReadFSFromBlockstore(t *testing.T) {
+	bserv := mdtest.Bserv()
+	ds := merkledag.NewDAGService(bserv)
+	dirNode := buildRootNode(t, ds, map[string][]byte{
+		"hello.txt":     []byte("hello"),
+		"dir/world.txt": []byte("world"),
+	}, nil)
+	fileNode := utest.GetNode(t, ds, []byte("not a directory"), utest.UseCidV1)
+	bs := blockServiceStore{bserv: bserv}
+
+	t.Run("directory", func(t *testing.T) {
+		fsys, err := ReadFSFromBlockstore(context.Background(), bs, dirNode.Cid())
+		if err != nil {
+			t.Fatalf("ReadFSFromBlockstore: %v", err)
+		}
+		if err := fstest.TestFS(fsys, "hello.txt", "dir/world.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if got := fsys.RootCid(); !got.Equals(dirNode.Cid()) {
+			t.Errorf("got root %s, wanted %s", got, dirNode.Cid())
+		}
+	})
+
+	t.Run("missing root", func(t *testing.T) {
+		missing := utest.GetNode(t, mdtest.Mock(), []byte("missing"), utest.UseCidV1)
+		_, err := ReadFSFromBlockstore(context.Background(), bs, missing.Cid())
+		if !ipld.IsNotFound(err) {
+			t.Errorf("got error %v, wanted not found", err)
+		}
+	})
+
+	t.Run("file root", func(t *testing.T) {
+		_, err := ReadFSFromBlockstore(context.Background(), bs, fileNode.Cid())
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got error %v, wanted %v", err, fs.ErrInvalid)
+		}
+	})
+}
+
+// writeCar returns a CARv1 listing the supplied roots and containing every block reachable from them.
+func writeCar(t testing.TB, ds ipld.DAGService, roots ...ipld.Node) []byte {
+	t.Helper()
+
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(2)
 	if err != nil {
-		t.Fatalf("failed to glob: %v", err)
+		t.Fatalf("begin header: %v", err)
+	}
+	rl, err := ma.AssembleEntry("roots")
+	if err != nil {
+		t.Fatalf("assemble roots: %v", err)
+	}
+	la, err := rl.BeginList(int64(len(roots)))
+	if err != nil {
+		t.Fatalf("begin roots: %v", err)
+	}
+	for _, r := range roots {
+		if err := la.AssembleValue().AssignLink(cidlink.Link{Cid: r.Cid()}); err != nil {
+			t.Fatalf("assign root: %v", err)
+		}
+	}
+	if err := la.Finish(); err != nil {
+		t.Fatalf("finish roots: %v", err)
+	}
+	if err := ma.AssembleKey().AssignString("version"); err != nil {
+		t.Fatalf("assemble version: %v", err)
+	}
+	if err := ma.AssembleValue().AssignInt(1); err != nil {
+		t.Fatalf("assign version: %v", err)
+	}
+	if err := ma.Finish(); err != nil {
+		t.Fatalf("finish header: %v", err)
 	}
 
-	want := []string{
-		"test/hello2.txt",
-		"test/goodbye.txt",
+	var hdr bytes.Buffer
+	if err := dagcbor.Encode(nb.Build(), &hdr); err != nil {
+		t.Fatalf("encode header: %v", err)
 	}
 
-	if diff := cmp.Diff(want, got, ignoreSliceOrder); diff != "" {
-		t.Errorf("Glob() mismatch (-want +got):\n%s", diff)
+	var buf bytes.Buffer
+	writeSection := func(parts ...[]byte) {
+		var size int
+		for _, p := range parts {
+			size += len(p)
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(size)))
+		for _, p := range parts {
+			buf.Write(p)
+		}
+	}
+	writeSection(hdr.Bytes())
+
+	seen := make(map[cid.Cid]bool)
+	var add func(c cid.Cid)
+	add = func(c cid.Cid) {
+		if seen[c] {
+			return
+		}
+		seen[c] = true
+		nd, err := ds.Get(context.Background(), c)
+		if err != nil {
+			t.Fatalf("get %s: %v", c, err)
+		}
+		writeSection(c.Bytes(), nd.RawData())
+		for _, l := range nd.Links() {
+			add(l.Cid)
+		}
+	}
+	for _, r := range roots {
+		add(r.Cid())
 	}
+	return buf.Bytes()
+}
+
+// wrapCarV2 returns a CARv2 with no index wrapping the supplied CARv1.
+func wrapCarV2(v1 []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(carV2Pragma)
+	var hdr [40]byte
+	binary.LittleEndian.PutUint64(hdr[16:], carV2HeaderSize)
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(len(v1)))
+	buf.Write(hdr[:])
+	buf.Write(v1)
+	return buf.Bytes()
+}
+
+var (
+	hamtFixtureOnce sync.Once
+	hamtFixture     *FS
+)
+
+// hamtFS returns an FS whose root is a HAMT sharded directory holding 100,000 files.
+func hamtFS(b *testing.B) *FS {
+	hamtFixtureOnce.Do(func() {
+		ds := mdtest.Mock()
+		filenode := utest.GetNode(b, ds, []byte("content"), utest.UseCidV1)
+
+		nodes := make(map[string]ipld.Node, 100000)
+		for i := 0; i < 100000; i++ {
+			nodes[fmt.Sprintf("file%06d", i)] = filenode
+		}
+		hamtFixture = buildFSWithNodes(b, ds, nil, nodes)
+	})
+	return hamtFixture
+}
+
+func BenchmarkReadDirHAMT(b *testing.B) {
+	fsys := hamtFS(b)
+
+	b.Run("fs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := fsys.ReadDir("."); err != nil {
+				b.Fatalf("failed to read dir: %v", err)
+			}
+		}
+	})
+
+	b.Run("dir_all", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			f, err := fsys.Open(".")
+			if err != nil {
+				b.Fatalf("failed to open dir: %v", err)
+			}
+			if _, err := f.(fs.ReadDirFile).ReadDir(-1); err != nil {
+				b.Fatalf("failed to read dir: %v", err)
+			}
+		}
+	})
+
+	b.Run("dir_paged", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			f, err := fsys.Open(".")
+			if err != nil {
+				b.Fatalf("failed to open dir: %v", err)
+			}
+			for {
+				_, err := f.(fs.ReadDirFile).ReadDir(1000)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					b.Fatalf("failed to read dir: %v", err)
+				}
+			}
+		}
+	})
 }
 
 var ignoreSliceOrder = cmpopts.SortSlices(func(a, b string) bool { return a < b })
 
-func buildFS(t *testing.T, ds ipld.DAGService, files map[string][]byte) *FS {
+// shardedFS returns an FS whose root is a HAMT sharded directory holding a file for each name.
+func shardedFS(t testing.TB, names []string) *FS {
+	t.Helper()
+
+	// Force sharding regardless of the number of entries
+	defer func(size int) { uio.HAMTShardingSize = size }(uio.HAMTShardingSize)
+	uio.HAMTShardingSize = 1
+
+	ds := mdtest.Mock()
+	nodes := make(map[string]ipld.Node, len(names))
+	for _, name := range names {
+		nodes[name] = utest.GetNode(t, ds, []byte(name), utest.UseCidV1)
+	}
+
+	dirnode := buildRootNode(t, ds, nil, nodes)
+	if !isShardNode(dirnode) {
+		t.Fatalf("root directory is not sharded")
+	}
+	return mustReadFS(t, dirnode, ds)
+}
+
+func buildFS(t testing.TB, ds ipld.DAGService, files map[string][]byte) *FS {
+	t.Helper()
+	return buildFSWithNodes(t, ds, files, nil)
+}
+
+// buildFSWithNodes returns an FS whose root directory holds the files described by files, as for
+// buildFS, and the supplied prebuilt nodes.
+func buildFSWithNodes(t testing.TB, ds ipld.DAGService, files map[string][]byte, nodes map[string]ipld.Node) *FS {
+	t.Helper()
+	return mustReadFS(t, buildRootNode(t, ds, files, nodes), ds)
+}
+
+// buildRootNode returns the root node of a directory holding the files described by files, as for
+// buildUnixFS, and the supplied prebuilt nodes under their map keys. The root node and every
+// prebuilt node are added to ds.
+func buildRootNode(t testing.TB, ds ipld.DAGService, files map[string][]byte, nodes map[string]ipld.Node) ipld.Node {
 	t.Helper()
+	ctx := context.Background()
 
 	dir := buildUnixFS(t, ds, files)
+	for name, node := range nodes {
+		if err := ds.Add(ctx, node); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+		if err := dir.AddChild(ctx, name, node); err != nil {
+			t.Fatalf("failed to add child %s: %v", name, err)
+		}
+	}
+
 	dirnode, err := dir.GetNode()
 	if err != nil {
 		t.Fatalf("failed to get root directory node: %v", err)
 	}
+	if err := ds.Add(ctx, dirnode); err != nil {
+		t.Fatalf("failed to add root directory node: %v", err)
+	}
+	return dirnode
+}
 
-	fsys, err := ReadFS(dirnode, ds)
+// mustReadFS returns an FS rooted at the node whose other nodes are read from getter.
+func mustReadFS(t testing.TB, node ipld.Node, getter ipld.NodeGetter) *FS {
+	t.Helper()
+	fsys, err := ReadFS(node, getter)
 	if err != nil {
 		t.Fatalf("failed to create fs: %v", err)
 	}
 	return fsys
 }
 
-func buildUnixFS(t *testing.T, ds ipld.DAGService, files map[string][]byte) uio.Directory {
+func buildUnixFS(t testing.TB, ds ipld.DAGService, files map[string][]byte) uio.Directory {
 	t.Helper()
 
 	root := uio.NewDirectory(ds)
@@ -265,7 +4762,7 @@ func buildUnixFS(t *testing.T, ds ipld.DAGService, files map[string][]byte) uio.
 	return root
 }
 
-func addFileToDir(t *testing.T, parent uio.Directory, ds ipld.DAGService, fpath string, content []byte) (uio.Directory, error) {
+func addFileToDir(t testing.TB, parent uio.Directory, ds ipld.DAGService, fpath string, content []byte) (uio.Directory, error) {
 	t.Helper()
 
 	if !strings.Contains(fpath, "/") {
@@ -330,3 +4827,98 @@ func addFileToDir(t *testing.T, parent uio.Directory, ds ipld.DAGService, fpath
 
 	return parent, nil
 }
+
+func BenchmarkReadFile(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 8<<16) // 8 MiB
+	fsys := buildFS(b, mdtest.Mock(), map[string][]byte{
+		"file": content,
+	})
+
+	b.Run("readfile", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(content)))
+		for i := 0; i < b.N; i++ {
+			if _, err := fsys.ReadFile("file"); err != nil {
+				b.Fatalf("failed to read file: %v", err)
+			}
+		}
+	})
+
+	b.Run("readall", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(content)))
+		for i := 0; i < b.N; i++ {
+			f, err := fsys.Open("file")
+			if err != nil {
+				b.Fatalf("failed to open file: %v", err)
+			}
+			if _, err := io.ReadAll(f); err != nil {
+				b.Fatalf("failed to read file: %v", err)
+			}
+			f.Close()
+		}
+	})
+}
+
+func BenchmarkReadDirLarge(b *testing.B) {
+	files := make(map[string][]byte, 5000)
+	for i := 0; i < 5000; i++ {
+		files[fmt.Sprintf("file%04d", i)] = []byte(fmt.Sprintf("content %d", i))
+	}
+	ds := mdtest.Mock()
+	cg := &countingGetter{NodeGetter: ds}
+	fsys := mustReadFS(b, buildFS(b, ds, files).root, cg)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	before := cg.count()
+	for i := 0; i < b.N; i++ {
+		entries, err := fsys.ReadDir(".")
+		if err != nil {
+			b.Fatalf("failed to read dir: %v", err)
+		}
+		if len(entries) != len(files) {
+			b.Fatalf("got %d entries, wanted %d", len(entries), len(files))
+		}
+	}
+	b.ReportMetric(float64(cg.count()-before)/float64(b.N), "fetches/op")
+}
+
+func BenchmarkWalkDeepTree(b *testing.B) {
+	files := map[string][]byte{}
+	var addDir func(dir string, depth int)
+	addDir = func(dir string, depth int) {
+		for i := 0; i < 3; i++ {
+			files[path.Join(dir, fmt.Sprintf("file%d", i))] = []byte(fmt.Sprintf("%s %d", dir, i))
+		}
+		if depth == 0 {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			addDir(path.Join(dir, fmt.Sprintf("dir%d", i)), depth-1)
+		}
+	}
+	addDir("", 8)
+
+	ds := mdtest.Mock()
+	cg := &countingGetter{NodeGetter: ds}
+	fsys := mustReadFS(b, buildFS(b, ds, files).root, cg)
+
+	walkers := map[string]func(root string, fn fs.WalkDirFunc) error{
+		"walkdir": func(root string, fn fs.WalkDirFunc) error { return fs.WalkDir(fsys, root, fn) },
+		"walk":    fsys.Walk,
+	}
+	for _, name := range []string{"walkdir", "walk"} {
+		walk := walkers[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			before := cg.count()
+			for i := 0; i < b.N; i++ {
+				if err := walk(".", func(path string, d fs.DirEntry, err error) error { return err }); err != nil {
+					b.Fatalf("failed to walk: %v", err)
+				}
+			}
+			b.ReportMetric(float64(cg.count()-before)/float64(b.N), "fetches/op")
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package mfsng
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var _ ipld.NodeGetter = fallbackGetter(nil)
+
+// fallbackGetter is an ipld.NodeGetter that tries each of its getters in order until one of them
+// returns the requested node.
+type fallbackGetter []ipld.NodeGetter
+
+// Get returns the node from the first getter that has it. It returns ipld.ErrNotFound if none of
+// the getters has the node.
+func (g fallbackGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	for _, getter := range g {
+		node, err := getter.Get(ctx, c)
+		if err == nil {
+			return node, nil
+		}
+		if !errors.Is(err, ipld.ErrNotFound{}) {
+			return nil, err
+		}
+	}
+	return nil, ipld.ErrNotFound{Cid: c}
+}
+
+// GetMany returns a channel of the requested nodes, each obtained using Get.
+func (g fallbackGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(ch)
+		for _, c := range cids {
+			node, err := g.Get(ctx, c)
+			select {
+			case ch <- &ipld.NodeOption{Node: node, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+var _ ipld.NodeGetter = (*verifyingGetter)(nil)
+
+// verifyingGetter is an ipld.NodeGetter that checks the data of every node it returns hashes to
+// the node's CID.
+type verifyingGetter struct {
+	getter ipld.NodeGetter
+}
+
+// Get returns the node from the underlying getter, or an error wrapping ErrBlockVerification if the
+// node's data does not match the requested CID.
+func (g *verifyingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	node, err := g.getter.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyNode(c, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// GetMany returns a channel of the requested nodes, each verified against the CID it was returned for.
+func (g *verifyingGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(ch)
+		for opt := range g.getter.GetMany(ctx, cids) {
+			if opt.Err == nil {
+				if err := verifyNode(opt.Node.Cid(), opt.Node); err != nil {
+					opt = &ipld.NodeOption{Err: err}
+				}
+			}
+			select {
+			case ch <- opt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// verifyNode checks that the raw data of the node hashes to the CID c.
+func verifyNode(c cid.Cid, node ipld.Node) error {
+	actual, err := c.Prefix().Sum(node.RawData())
+	if err != nil {
+		return fmt.Errorf("hash block %s: %w", c, err)
+	}
+	if !actual.Equals(c) {
+		return fmt.Errorf("block %s: %w", c, ErrBlockVerification)
+	}
+	return nil
+}
+
+var _ ipld.NodeGetter = blockGetter(nil)
+
+// blockGetter is an ipld.NodeGetter over a set of blocks held in memory, decoding each block when
+// it is requested.
+type blockGetter map[cid.Cid]blocks.Block
+
+// Get decodes and returns the block with the supplied CID. It returns ipld.ErrNotFound if there
+// is no such block.
+func (g blockGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	b, ok := g[c]
+	if !ok {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	node, err := ipld.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("decode block %s: %w", c, err)
+	}
+	return node, nil
+}
+
+// GetMany returns a channel of the requested nodes, each obtained using Get.
+func (g blockGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	for _, c := range cids {
+		node, err := g.Get(ctx, c)
+		ch <- &ipld.NodeOption{Node: node, Err: err}
+	}
+	close(ch)
+	return ch
+}
+
+var _ ipld.NodeGetter = storeGetter{}
+
+// storeGetter is an ipld.NodeGetter that reads blocks from a Blockstore and decodes them.
+type storeGetter struct {
+	bs Blockstore
+}
+
+// Get reads and decodes the block with the supplied CID.
+func (g storeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	b, err := g.bs.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	node, err := ipld.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("decode block %s: %w", c, err)
+	}
+	return node, nil
+}
+
+// GetMany returns a channel of the requested nodes, each obtained using Get.
+func (g storeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(ch)
+		for _, c := range cids {
+			node, err := g.Get(ctx, c)
+			select {
+			case ch <- &ipld.NodeOption{Node: node, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,128 @@
+package mfsng
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var _ fs.GlobFS = (*FS)(nil)
+
+// Glob returns the names of all files matching pattern, in lexical order, using the syntax of
+// path.Match. Only the directories that the elements of the pattern can match are read, links
+// that cannot be directories are never followed, and the nodes of matching entries in the final
+// directory are never loaded. As with fs.Glob, errors reading directories are ignored and the only
+// possible error is path.ErrBadPattern.
+func (fsys *FS) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if pattern == "." {
+		return []string{"."}, nil
+	}
+	if fsys.closed.Load() {
+		return nil, nil
+	}
+
+	ctx := fsys.context()
+	rootDir, err := fsys.rootDir()
+	if err != nil {
+		return nil, nil
+	}
+	dirs := []globDir{{node: fsys.root, udir: rootDir}}
+
+	segments := strings.Split(pattern, "/")
+	for _, seg := range segments[:len(segments)-1] {
+		var next []globDir
+		for _, d := range dirs {
+			if !hasMeta(seg) {
+				node, err := d.udir.Find(ctx, seg)
+				if err != nil {
+					continue
+				}
+				if gd, ok := fsys.globDir(ctx, path.Join(d.path, seg), node); ok {
+					next = append(next, gd)
+				}
+				continue
+			}
+
+			links, err := fsys.dirLinks(d.node, d.udir)
+			if err != nil {
+				continue
+			}
+			for _, l := range links {
+				if ok, _ := path.Match(seg, l.Name); !ok {
+					continue
+				}
+				if l.Cid.Type() != cid.DagProtobuf {
+					// Only dag-pb nodes can be directories or symbolic links
+					continue
+				}
+				node, err := l.GetNode(ctx, fsys.getter)
+				if err != nil {
+					continue
+				}
+				if gd, ok := fsys.globDir(ctx, path.Join(d.path, l.Name), node); ok {
+					next = append(next, gd)
+				}
+			}
+		}
+		dirs = next
+	}
+
+	var matches []string
+	last := segments[len(segments)-1]
+	for _, d := range dirs {
+		links, err := fsys.dirLinks(d.node, d.udir)
+		if err != nil {
+			continue
+		}
+		for _, l := range links {
+			if ok, _ := path.Match(last, l.Name); ok {
+				matches = append(matches, path.Join(d.path, l.Name))
+			}
+		}
+	}
+	return matches, nil
+}
+
+// globDir is a directory whose entries are being matched against an element of a glob pattern.
+type globDir struct {
+	path string
+	node ipld.Node
+	udir uio.Directory
+}
+
+// globDir returns the directory at p, whose node has already been loaded, following the node if it
+// is a symbolic link. It reports false if the node is not a directory.
+func (fsys *FS) globDir(ctx context.Context, p string, node ipld.Node) (globDir, bool) {
+	if _, ok := symlinkTarget(node); ok {
+		var err error
+		node, _, err = fsys.locateNode(ctx, "glob", p)
+		if err != nil {
+			return globDir{}, false
+		}
+	}
+
+	node, err := unwrapMetadata(ctx, node, fsys.getter)
+	if err != nil {
+		return globDir{}, false
+	}
+	udir, err := uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(fsys.getter), node)
+	if err != nil {
+		return globDir{}, false
+	}
+	return globDir{path: p, node: node, udir: udir}, true
+}
+
+// hasMeta reports whether the pattern element contains any of the special characters recognized
+// by path.Match.
+func hasMeta(elem string) bool {
+	return strings.ContainsAny(elem, `*?[\`)
+}
@@ -0,0 +1,57 @@
+package mfsng
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// FileServer returns an http.Handler that serves the contents of the FS. Files are served using
+// http.ServeContent, so Range requests seek within the file's DAG and read only the blocks
+// covering the requested bytes, and the UnixFS modification time, when recorded, is sent as
+// Last-Modified. Each file's ETag is its CID so conditional requests can be answered without
+// reading any content. Files are opened and read using the request's context, so a request that is
+// cancelled stops reading. Directories and errors are handled as by http.FileServer.
+func (fsys *FS) FileServer() http.Handler {
+	return &fileServer{
+		fsys:     fsys,
+		fallback: http.FileServer(http.FS(fsys)),
+	}
+}
+
+type fileServer struct {
+	fsys     *FS
+	fallback http.Handler
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// http.FileServer redirects requests for index.html to the directory containing it
+	if strings.HasSuffix(r.URL.Path, "/index.html") {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	f, err := s.fsys.OpenFile(name, WithReadContext(r.Context()))
+	if err != nil {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+
+	file, ok := f.(*File)
+	if !ok {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	if c := file.Cid(); c.Defined() {
+		w.Header().Set("Etag", `"`+c.String()+`"`)
+	}
+	http.ServeContent(w, r, file.Name(), file.info.modtime, file)
+}
@@ -0,0 +1,70 @@
+package mfsng
+
+import (
+	"io/fs"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// A Symlink is a UnixFS symbolic link. It is returned by ReadDir for entries that are symbolic
+// links, and by Open only when a link is encountered somewhere other than a path, such as through
+// a metadata node. Symbolic links have no content, so reading from a Symlink returns an error.
+type Symlink struct {
+	target string
+	info   FileInfo
+}
+
+func newSymlink(name string, node ipld.Node, fsn *unixfs.FSNode) *Symlink {
+	target := string(fsn.Data())
+	return &Symlink{
+		target: target,
+		info: FileInfo{
+			name:     name,
+			size:     int64(len(target)),
+			filemode: fs.ModeSymlink | unixfsMode(fsn),
+			modtime:  fsn.ModTime(),
+			node:     node,
+		},
+	}
+}
+
+// Target returns the path the symbolic link points to, exactly as it is recorded in the link.
+func (s *Symlink) Target() string { return s.target }
+
+// Stat returns a FileInfo describing the symbolic link. Its size is the length of the target.
+func (s *Symlink) Stat() (fs.FileInfo, error) {
+	return &s.info, nil
+}
+
+func (s *Symlink) Name() string               { return s.info.name }
+func (s *Symlink) IsDir() bool                { return false }
+func (s *Symlink) Info() (fs.FileInfo, error) { return s.Stat() }
+func (s *Symlink) Type() fs.FileMode          { return fs.ModeSymlink }
+func (s *Symlink) fileInfo() *FileInfo        { return &s.info }
+
+// Cid returns the CID of the symbolic link's node.
+func (s *Symlink) Cid() cid.Cid { return s.info.Cid() }
+
+func (s *Symlink) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: s.info.name, Err: fs.ErrInvalid}
+}
+
+func (s *Symlink) Close() error {
+	return nil
+}
+
+// symlinkTarget returns the target of the node if it is a UnixFS symbolic link.
+func symlinkTarget(node ipld.Node) (string, bool) {
+	pn, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return "", false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil || fsn.Type() != unixfs.TSymlink {
+		return "", false
+	}
+	return string(fsn.Data()), true
+}
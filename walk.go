@@ -0,0 +1,458 @@
+package mfsng
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Count returns the number of files and directories in the subtree rooted at the named directory,
+// not including the directory itself. Entries are counted once for each path they appear at, but a
+// directory that is shared by several paths is only traversed once. Symbolic links are neither
+// files nor directories so they are not counted, and they are not followed. Only directory nodes
+// and the root nodes of files are loaded, file content is not read.
+func (fsys *FS) Count(root string) (files int, dirs int, err error) {
+	if err := ValidatePath(root); err != nil {
+		return 0, 0, &fs.PathError{
+			Op:   "count",
+			Path: root,
+			Err:  err,
+		}
+	}
+	if root == "." {
+		root = ""
+	}
+
+	node, _, err := fsys.locateNode(fsys.context(), "count", root)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	counts, err := fsys.countDir(fsys.context(), node, make(map[cid.Cid]dirCounts))
+	if err != nil {
+		return 0, 0, newPathError("count", root, node.Cid(), err)
+	}
+
+	return counts.files, counts.dirs, nil
+}
+
+type dirCounts struct {
+	files int
+	dirs  int
+}
+
+// countDir counts the entries in the directory node and all of its subdirectories. seen records
+// the counts for directories that have already been traversed.
+func (fsys *FS) countDir(ctx context.Context, node ipld.Node, seen map[cid.Cid]dirCounts) (dirCounts, error) {
+	node, err := unwrapMetadata(ctx, node, fsys.getter)
+	if err != nil {
+		return dirCounts{}, err
+	}
+	if counts, ok := seen[node.Cid()]; ok {
+		return counts, nil
+	}
+
+	udir, err := uio.NewDirectoryFromNode(merkledag.NewReadOnlyDagService(fsys.getter), node)
+	if err != nil {
+		if errors.Is(err, uio.ErrNotADir) {
+			return dirCounts{}, errNotDir(node)
+		}
+		return dirCounts{}, fmt.Errorf("new directory from node: %w", err)
+	}
+
+	var counts dirCounts
+	if err := udir.ForEachLink(ctx, func(l *ipld.Link) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		child, err := l.GetNode(ctx, fsys.getter)
+		if err != nil {
+			return fmt.Errorf("get %s: %w", l.Name, err)
+		}
+		child, err = unwrapMetadata(ctx, child, fsys.getter)
+		if err != nil {
+			return fmt.Errorf("%s: %w", l.Name, err)
+		}
+
+		if _, ok := symlinkTarget(child); ok {
+			return nil
+		}
+		if !isDirNode(child) {
+			counts.files++
+			return nil
+		}
+
+		sub, err := fsys.countDir(ctx, child, seen)
+		if err != nil {
+			return err
+		}
+		counts.dirs += 1 + sub.dirs
+		counts.files += sub.files
+		return nil
+	}); err != nil {
+		return dirCounts{}, err
+	}
+
+	seen[node.Cid()] = counts
+	return counts, nil
+}
+
+// UniqueBlocks returns the number of distinct blocks in the DAG rooted at the named file or
+// directory, including its root node, and their total size in bytes. Each block is counted once
+// no matter how many paths it can be reached by, so this reports the storage needed to hold the
+// subtree. Every block in the subtree is loaded, including all file content.
+func (fsys *FS) UniqueBlocks(root string) (count int, bytes uint64, err error) {
+	if err := ValidatePath(root); err != nil {
+		return 0, 0, &fs.PathError{
+			Op:   "uniqueblocks",
+			Path: root,
+			Err:  err,
+		}
+	}
+	if root == "." {
+		root = ""
+	}
+
+	ctx := fsys.context()
+	node, _, err := fsys.locateNode(ctx, "uniqueblocks", root)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	seen := map[cid.Cid]struct{}{node.Cid(): {}}
+	stack := []ipld.Node{node}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, newPathError("uniqueblocks", root, node.Cid(), err)
+		}
+
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		count++
+		bytes += uint64(len(n.RawData()))
+
+		for _, l := range n.Links() {
+			if _, ok := seen[l.Cid]; ok {
+				continue
+			}
+			seen[l.Cid] = struct{}{}
+
+			child, err := l.GetNode(ctx, fsys.getter)
+			if err != nil {
+				return 0, 0, newPathError("uniqueblocks", root, l.Cid, fmt.Errorf("get node: %w", err))
+			}
+			stack = append(stack, child)
+		}
+	}
+
+	return count, bytes, nil
+}
+
+// isDirNode reports whether the node is a UnixFS directory or HAMT shard.
+func isDirNode(node ipld.Node) bool {
+	pn, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return false
+	}
+	return fsn.IsDir()
+}
+
+// isMetadataNode reports whether the node is a UnixFS metadata node wrapping another node.
+func isMetadataNode(node ipld.Node) bool {
+	pn, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return false
+	}
+	return fsn.Type() == unixfs.TMetadata
+}
+
+// isShardNode reports whether the node is the root of a HAMT sharded UnixFS directory.
+func isShardNode(node ipld.Node) bool {
+	pn, ok := node.(*merkledag.ProtoNode)
+	if !ok {
+		return false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return false
+	}
+	return fsn.Type() == unixfs.THAMTShard
+}
+
+// A ManifestEntry describes a single file or directory within an FS.
+type ManifestEntry struct {
+	Path string      // path of the entry relative to the root of the FS
+	Cid  cid.Cid     // CID of the entry's root node
+	Size int64       // size of the entry as reported by its FileInfo
+	Type fs.FileMode // type bits of the entry
+}
+
+// Manifest returns an entry for every file and directory in the subtree rooted at the named
+// directory, not including the directory itself. Entries are listed depth first with the
+// entries of each directory sorted by name.
+func (fsys *FS) Manifest(root string) ([]ManifestEntry, error) {
+	if err := ValidatePath(root); err != nil {
+		return nil, &fs.PathError{
+			Op:   "manifest",
+			Path: root,
+			Err:  err,
+		}
+	}
+	if root == "." {
+		root = ""
+	}
+
+	d, err := fsys.openDir(fsys.context(), "manifest", root)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ManifestEntry
+	if err := fsys.walkTree(fsys.context(), root, d, func(p string, l *ipld.Link, e entry) error {
+		info := e.fileInfo()
+		entries = append(entries, ManifestEntry{
+			Path: p,
+			Cid:  l.Cid,
+			Size: info.Size(),
+			Type: info.Mode().Type(),
+		})
+		return nil
+	}, nil); err != nil {
+		return nil, newPathError("manifest", root, d.Cid(), err)
+	}
+	return entries, nil
+}
+
+// WritePathList writes the path of every file and directory in the subtree rooted at the named
+// directory to w, one per line, not including the directory itself. Paths are written depth first
+// with the entries of each directory sorted by name, in the same order as Manifest, but are
+// streamed to w as the tree is traversed rather than being held in memory.
+func (fsys *FS) WritePathList(ctx context.Context, root string, w io.Writer) error {
+	if err := ValidatePath(root); err != nil {
+		return &fs.PathError{
+			Op:   "pathlist",
+			Path: root,
+			Err:  err,
+		}
+	}
+	if root == "." {
+		root = ""
+	}
+
+	d, err := fsys.openDir(ctx, "pathlist", root)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := fsys.walkTree(ctx, root, d, func(p string, _ *ipld.Link, _ entry) error {
+		if _, err := bw.WriteString(p + "\n"); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		return nil
+	}, nil); err != nil {
+		return newPathError("pathlist", root, d.Cid(), err)
+	}
+	if err := bw.Flush(); err != nil {
+		return newPathError("pathlist", root, d.Cid(), err)
+	}
+	return nil
+}
+
+// walkTree calls visit for every entry beneath the directory d, depth first with the entries of
+// each directory sorted by name, passing the entry's path formed by joining dirpath and its name.
+// A directory is visited before its entries and leave, if not nil, is called once they have all
+// been visited. Entries other than directories are closed when visit returns. An entry whose name
+// cannot be used as a single path element results in an error wrapping fs.ErrInvalid.
+func (fsys *FS) walkTree(ctx context.Context, dirpath string, d *Dir, visit func(p string, l *ipld.Link, e entry) error, leave func(p string, d *Dir) error) error {
+	links, err := sortedLinks(ctx, d.udir)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !isLocalName(l.Name) {
+			return fmt.Errorf("%q: unsafe name: %w", l.Name, fs.ErrInvalid)
+		}
+		p := path.Join(dirpath, l.Name)
+
+		e, err := linkEntry(ctx, fsys.getter, l)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+
+		sub, isDir := e.(*Dir)
+		err = visit(p, l, e)
+		if !isDir {
+			e.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if !isDir {
+			continue
+		}
+
+		if err := fsys.walkTree(ctx, p, sub, visit, leave); err != nil {
+			return err
+		}
+		if leave != nil {
+			if err := leave(p, sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Walk walks the file tree rooted at root, calling fn for each file or directory in the tree,
+// including root, with the same semantics as fs.WalkDir: entries are visited in lexical order, and
+// fn may return fs.SkipDir or fs.SkipAll to skip part or all of the remaining tree. Unlike
+// fs.WalkDir, each directory is listed using the node already loaded to determine that it is a
+// directory rather than resolving its path again from the root, so every node in the tree is loaded
+// at most once per path. Entries other than root are *DirEntry values.
+func (fsys *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	ctx := fsys.context()
+
+	var err error
+	var e entry
+	if err = ValidatePath(root); err != nil {
+		err = &fs.PathError{Op: "walk", Path: root, Err: err}
+	} else {
+		lookup := root
+		if lookup == "." {
+			lookup = ""
+		}
+		var node ipld.Node
+		var name string
+		node, name, err = fsys.locateNode(ctx, "walk", lookup)
+		if err == nil {
+			e, err = newEntry(ctx, name, node, fsys.getter)
+			if err != nil {
+				err = newPathError("walk", root, node.Cid(), err)
+			}
+		}
+	}
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		defer e.Close()
+		err = fsys.walk(ctx, root, fs.FileInfoToDirEntry(e.fileInfo()), e, fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk calls fn for the entry e at path p and, if it is a directory, for each entry within it.
+func (fsys *FS) walk(ctx context.Context, p string, de fs.DirEntry, e entry, fn fs.WalkDirFunc) error {
+	d, ok := e.(*Dir)
+	if err := fn(p, de, nil); err != nil || !ok {
+		if err == fs.SkipDir && de.IsDir() {
+			// Successfully skipped directory
+			err = nil
+		}
+		return err
+	}
+
+	links, err := sortedLinks(ctx, d.udir)
+	if err != nil {
+		// Second call, to report the error listing the directory
+		err = fn(p, de, newPathError("walk", p, d.info.Cid(), err))
+		if err != nil {
+			if err == fs.SkipDir && de.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		child := newDirEntry(ctx, fsys.getter, l)
+		name := path.Join(p, l.Name)
+
+		// Loading the entry here means a subdirectory is listed from the node loaded to find its type
+		ce, err := child.load()
+		if err != nil {
+			if err := fn(name, child, err); err != nil {
+				if err == fs.SkipDir {
+					break
+				}
+				return err
+			}
+			continue
+		}
+
+		if err := fsys.walk(ctx, name, child, ce, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedLinks returns the links of the directory sorted by name.
+func sortedLinks(ctx context.Context, udir uio.Directory) ([]*ipld.Link, error) {
+	var links []*ipld.Link
+	if err := forEachLink(ctx, udir, func(l *ipld.Link) bool {
+		links = append(links, l)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+	return links, nil
+}
+
+// errStopIteration is used to end a listing of links early.
+var errStopIteration = errors.New("stop iteration")
+
+// forEachLink calls fn for each link of the directory in directory order, loading the internal
+// nodes of a sharded directory only as they are reached. It stops when fn returns false or the
+// context is cancelled.
+func forEachLink(ctx context.Context, udir uio.Directory, fn func(l *ipld.Link) bool) error {
+	err := udir.ForEachLink(ctx, func(l *ipld.Link) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(l) {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
+		return fmt.Errorf("list links: %w", err)
+	}
+	return nil
+}